@@ -0,0 +1,298 @@
+package tamago
+
+import (
+	"sync"
+)
+
+const (
+	// SampleRate is the number of stereo samples per second output by the APU.
+	SampleRate = 44100
+
+	// The frame sequencer clocks the length counters, sweep and envelopes at 512 Hz.
+	frameSequencerCycles = cps / 512
+
+	// At most a quarter of a second of samples (4 bytes each) are buffered,
+	// so audio doesn't lag behind if it isn't read fast enough.
+	maxBufferSize = SampleRate / 4 * 4
+
+	// How quickly the high-pass filter removes the DC offset of the DACs, per sample.
+	capacitorCharge = 0.996
+)
+
+// The bits that always read back as set in each register from 0xff10 to 0xff2f.
+// This includes write-only and unused bits.
+var apuReadMasks = [0x20]uint8{
+	0x80, 0x3f, 0x00, 0xff, 0xbf, // NR10-NR14
+	0xff, 0x3f, 0x00, 0xff, 0xbf, // NR20-NR24
+	0x7f, 0xff, 0x9f, 0xff, 0xbf, // NR30-NR34
+	0xff, 0xff, 0x00, 0x00, 0xbf, // NR40-NR44
+	0x00, 0x00, 0x70, // NR50-NR52
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+}
+
+// APU is the audio processing unit, which mixes four channels into stereo samples.
+// The samples are read as 16-bit signed little-endian PCM, with the left and right channels interleaved.
+// https://gbdev.io/pandocs/Audio.html
+type APU struct {
+	// The raw values of the registers from 0xff10 to 0xff2f.
+	regs    [0x20]uint8
+	waveRAM [0x10]uint8
+
+	power bool
+
+	ch1, ch2 *square
+	ch3      *wave
+	ch4      *noise
+
+	frame, frameTimer int
+
+	// A sample is output every time this exceeds the clock speed.
+	sampleTimer int
+
+	// The charge of the high-pass filter's capacitors for each stereo channel.
+	capLeft, capRight float64
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func NewAPU() *APU {
+	a := &APU{
+		ch1: newSquare(true),
+		ch2: newSquare(false),
+		ch4: newNoise(),
+	}
+	a.ch3 = newWave(a.waveRAM[:])
+
+	return a
+}
+
+// Read samples that have been output so far.
+// If not enough samples are available, the rest of p is filled with silence, so reading never blocks.
+func (a *APU) Read(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n := copy(p, a.buf)
+	a.buf = a.buf[:copy(a.buf, a.buf[n:])]
+
+	for i := n; i < len(p); i++ {
+		p[i] = 0
+	}
+
+	return len(p), nil
+}
+
+// Given the number of cycles run, step forward the channels and output samples.
+func (a *APU) step(cycles int) {
+	if !a.power {
+		a.sample(cycles)
+		return
+	}
+
+	a.ch1.step(cycles)
+	a.ch2.step(cycles)
+	a.ch3.step(cycles)
+	a.ch4.step(cycles)
+
+	a.frameTimer += cycles
+	for a.frameTimer >= frameSequencerCycles {
+		a.frameTimer -= frameSequencerCycles
+		a.clockFrame()
+	}
+
+	a.sample(cycles)
+}
+
+// Run the frame sequencer for one step.
+func (a *APU) clockFrame() {
+	// Length counters are clocked every other step (256 Hz).
+	if a.frame%2 == 0 {
+		a.ch1.length.clock(&a.ch1.on)
+		a.ch2.length.clock(&a.ch2.on)
+		a.ch3.length.clock(&a.ch3.on)
+		a.ch4.length.clock(&a.ch4.on)
+	}
+
+	// The sweep is clocked on steps 2 and 6 (128 Hz).
+	if a.frame == 2 || a.frame == 6 {
+		a.ch1.clockSweep()
+	}
+
+	// Envelopes are clocked on step 7 (64 Hz).
+	if a.frame == 7 {
+		a.ch1.envelope.clock()
+		a.ch2.envelope.clock()
+		a.ch4.envelope.clock()
+	}
+
+	a.frame = (a.frame + 1) % 8
+}
+
+// Output as many samples as fit into the number of cycles run.
+func (a *APU) sample(cycles int) {
+	a.sampleTimer += cycles * SampleRate
+
+	for a.sampleTimer >= cps {
+		a.sampleTimer -= cps
+
+		left, right := a.mix()
+		a.push(left, right)
+	}
+}
+
+// Mix the channels' outputs into a stereo sample.
+func (a *APU) mix() (float64, float64) {
+	if !a.power {
+		return 0, 0
+	}
+
+	var left, right float64
+
+	outputs := [4]struct {
+		dac   bool
+		value uint8
+	}{
+		{a.ch1.dac, a.ch1.output()},
+		{a.ch2.dac, a.ch2.output()},
+		{a.ch3.dac, a.ch3.output()},
+		{a.ch4.dac, a.ch4.output()},
+	}
+
+	// NR51 selects which channels are panned to each side.
+	panning := a.regs[0x15]
+
+	for i, o := range outputs {
+		if !o.dac {
+			continue
+		}
+
+		// The DACs convert the digital output (0-15) to an analog value from -1 to 1.
+		v := float64(o.value)/7.5 - 1
+
+		if (panning & (1 << (i + 4))) != 0 {
+			left += v
+		}
+
+		if (panning & (1 << i)) != 0 {
+			right += v
+		}
+	}
+
+	// NR50 sets the master volume for each side, where 0 is the lowest (but not silent).
+	volume := a.regs[0x14]
+	left *= float64((volume>>4)&0x07+1) / 8
+	right *= float64(volume&0x07+1) / 8
+
+	return left / 4, right / 4
+}
+
+// Filter a stereo sample and append it to the buffer.
+func (a *APU) push(left, right float64) {
+	left, a.capLeft = left-a.capLeft, left-(left-a.capLeft)*capacitorCharge
+	right, a.capRight = right-a.capRight, right-(right-a.capRight)*capacitorCharge
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.buf) >= maxBufferSize {
+		// Drop the older half of the buffer.
+		a.buf = a.buf[:copy(a.buf, a.buf[maxBufferSize/2:])]
+	}
+
+	var sample [4]byte
+	Endian.PutUint16(sample[0:], uint16(pcm(left)))
+	Endian.PutUint16(sample[2:], uint16(pcm(right)))
+
+	a.buf = append(a.buf, sample[:]...)
+}
+
+// Convert an analog value from -1 to 1 to a 16-bit signed sample, clipping it if out of range.
+func pcm(v float64) int16 {
+	switch {
+	case v > 1:
+		v = 1
+	case v < -1:
+		v = -1
+	}
+
+	return int16(v * 0x7fff)
+}
+
+func (a *APU) read(addr uint16) uint8 {
+	switch {
+
+	case addr >= 0xff30:
+		return a.waveRAM[addr-0xff30]
+
+	// NR52 reports the power and whether each channel is on.
+	case addr == 0xff26:
+		v := apuReadMasks[0x16] | tobit(a.power)<<7
+		for i, on := range []bool{a.ch1.on, a.ch2.on, a.ch3.on, a.ch4.on} {
+			v |= tobit(on) << i
+		}
+
+		return v
+
+	}
+
+	offset := addr - 0xff10
+
+	return a.regs[offset] | apuReadMasks[offset]
+}
+
+func (a *APU) write(addr uint16, val uint8) {
+	switch {
+
+	// Wave RAM is still accessible when the APU is off.
+	case addr >= 0xff30:
+		a.waveRAM[addr-0xff30] = val
+		return
+
+	case addr == 0xff26:
+		on := (val & 0x80) != 0
+
+		if a.power && !on {
+			a.reset()
+		} else if !a.power && on {
+			a.frame = 0
+		}
+
+		a.power = on
+		return
+
+	}
+
+	// All other registers are read-only when the APU is off.
+	if !a.power {
+		return
+	}
+
+	a.regs[addr-0xff10] = val
+
+	switch {
+
+	case addr <= 0xff14:
+		a.ch1.write(int(addr-0xff10), val)
+
+	case addr <= 0xff19:
+		a.ch2.write(int(addr-0xff15), val)
+
+	case addr <= 0xff1e:
+		a.ch3.write(int(addr-0xff1a), val)
+
+	case addr <= 0xff23:
+		a.ch4.write(int(addr-0xff1f), val)
+
+	}
+}
+
+// Clear all registers and turn off the channels, which happens when the APU is turned off.
+func (a *APU) reset() {
+	a.regs = [0x20]uint8{}
+
+	a.ch1 = newSquare(true)
+	a.ch2 = newSquare(false)
+	a.ch3 = newWave(a.waveRAM[:])
+	a.ch4 = newNoise()
+}
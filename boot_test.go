@@ -0,0 +1,116 @@
+package tamago
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// Create a 32KiB ROM with a valid header, which the bootrom accepts.
+func testROM() []uint8 {
+	rom := make([]uint8, romBankSize*2)
+	copy(rom[0x104:], nintendoLogo[:])
+
+	var hsum uint8
+	for _, b := range rom[0x134:0x14d] {
+		hsum = hsum - b - 1
+	}
+	rom[0x14d] = hsum
+
+	return rom
+}
+
+// Scale each bit of a nibble of the logo to two pixels, like the bootrom does.
+func scaleNibble(n uint8) uint8 {
+	var v uint8
+	for i := 3; i >= 0; i-- {
+		b := (n >> i) & 0x01
+		v = v<<2 | b<<1 | b
+	}
+
+	return v
+}
+
+func TestBootLogo(t *testing.T) {
+	boot, err := os.ReadFile("boot.gb")
+	if err != nil {
+		t.Skip("bootrom not found")
+	}
+
+	s := NewState()
+
+	if err := s.LoadBootFrom(bytes.NewReader(boot)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.LoadFrom(bytes.NewReader(testROM())); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bootrom takes a few seconds to scroll the logo down.
+	for i := 0; s.hasBoot; i++ {
+		if i > 20_000_000 {
+			t.Fatalf("bootrom didn't finish, PC is 0x%04x", s.PC)
+		}
+
+		s.step()
+	}
+
+	if lcdc := s.Read(0xff40); lcdc != 0x91 {
+		t.Errorf("LCDC is 0x%02x after the bootrom, expected 0x91", lcdc)
+	}
+
+	// Each nibble of the logo is decoded to two rows of a tile, starting from tile 1.
+	for i, b := range nintendoLogo {
+		for j, n := range []uint8{b >> 4, b & 0x0f} {
+			addr := 0x10 + (i*2+j)*4
+			expected := scaleNibble(n)
+
+			for _, offset := range []int{addr, addr + 2} {
+				if v := s.vram[offset]; v != expected {
+					t.Fatalf("VRAM 0x%04x is 0x%02x, expected 0x%02x", 0x8000+offset, v, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestBootSkippedForCGB(t *testing.T) {
+	rom := testROM()
+	rom[0x143] = 0x80
+
+	loadBoot := func(s *State) error { return s.LoadBootFrom(bytes.NewReader(make([]uint8, 0x100))) }
+	loadROM := func(s *State) error { return s.LoadFrom(bytes.NewReader(rom)) }
+
+	tests := []struct {
+		name  string
+		loads []func(s *State) error
+	}{
+		{"bootrom first", []func(s *State) error{loadBoot, loadROM}},
+		{"ROM first", []func(s *State) error{loadROM, loadBoot}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewState()
+
+			for _, load := range tt.loads {
+				if err := load(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if s.hasBoot || s.PC != 0x100 {
+				t.Errorf("bootrom wasn't skipped, PC is 0x%04x", s.PC)
+			}
+
+			if a := s.AF.Hi; a != 0x11 {
+				t.Errorf("A is 0x%02x, expected 0x11 for CGB mode", a)
+			}
+
+			if lcdc := s.Read(0xff40); lcdc != 0x91 {
+				t.Errorf("LCDC is 0x%02x, expected 0x91", lcdc)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package tamago
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	romBankSize = 0x4000
+	ramBankSize = 0x2000
+)
+
+var (
+	UnsupportedCartridgeErr = errors.New("cartridge type is not supported")
+	InvalidSaveErr          = errors.New("save is too small for the cartridge's RAM")
+	NoBatteryErr            = errors.New("cartridge has no battery to save to")
+
+	// Cartridge types with battery-backed RAM.
+	batteryTypes = map[uint8]bool{
+		0x03: true,
+		0x06: true,
+		0x09: true,
+		0x0d: true,
+		0x0f: true,
+		0x10: true,
+		0x13: true,
+		0x1b: true,
+		0x1e: true,
+		0x22: true,
+		0xff: true,
+	}
+
+	// The size of external RAM, indexed by the RAM size byte in the header (0x149).
+	// https://gbdev.io/pandocs/The_Cartridge_Header.html#0149---ram-size
+	ramSizes = map[uint8]int{
+		0x00: 0,
+		0x01: 0x800,
+		0x02: 0x2000,
+		0x03: 0x8000,
+		0x04: 0x20000,
+		0x05: 0x10000,
+	}
+)
+
+// Cartridge maps a game's ROM and external RAM into memory.
+// The MMU forwards all reads and writes in the ranges 0x0000-0x7fff and 0xa000-0xbfff to it.
+type Cartridge interface {
+	Read(addr uint16) uint8
+	Write(addr uint16, val uint8)
+
+	// Check if the cartridge's RAM is kept alive by a battery, and should be saved.
+	Battery() bool
+
+	// Return the contents of the cartridge's RAM (and clock, if any) as a save file.
+	Save() []uint8
+
+	// Restore the cartridge's RAM (and clock, if any) from a save file.
+	Restore(save []uint8) error
+
+	// Check if RAM (or the clock, if any) has changed since the last call to modified.
+	modified() bool
+
+	// Mark the cartridge as modified, so it is saved even if RAM isn't written to.
+	touch()
+}
+
+// clocked is implemented by cartridges with hardware that runs alongside the CPU, such as a real time clock.
+type clocked interface {
+	step(cycles int)
+}
+
+// Create a new cartridge from a ROM dump and its parsed header.
+// The mapper and RAM size are selected from the header, or the cartridge is ROM only if info is nil.
+func NewCartridge(rom []uint8, info *RomInfo) (Cartridge, error) {
+	if info == nil {
+		info = &RomInfo{}
+	}
+
+	// Pad the ROM to a power of two (at least 32KiB) so bank numbers can be wrapped around.
+	size := romBankSize * 2
+	for size < len(rom) {
+		size *= 2
+	}
+
+	buf := make([]uint8, size)
+	copy(buf, rom)
+
+	c := cartridge{
+		rom:     buf,
+		ram:     make([]uint8, info.RAMSize),
+		battery: info.Battery(),
+	}
+
+	switch kind := info.CartridgeType; kind {
+
+	// ROM only, optionally with RAM.
+	case 0x00, 0x08, 0x09:
+		return &ROMOnly{c}, nil
+
+	case 0x01, 0x02, 0x03:
+		return newMBC1(c), nil
+
+	case 0x05, 0x06:
+		return newMBC2(c), nil
+
+	case 0x0f, 0x10:
+		return newMBC3(c, true), nil
+
+	case 0x11, 0x12, 0x13:
+		return newMBC3(c, false), nil
+
+	case 0x19, 0x1a, 0x1b:
+		return newMBC5(c, false), nil
+
+	case 0x1c, 0x1d, 0x1e:
+		return newMBC5(c, true), nil
+
+	default:
+		return nil, fmt.Errorf("%w: 0x%02x", UnsupportedCartridgeErr, kind)
+
+	}
+}
+
+// cartridge holds the ROM and external RAM common to all mappers.
+type cartridge struct {
+	rom, ram []uint8
+
+	battery, dirty bool
+}
+
+func (c *cartridge) Battery() bool {
+	return c.battery
+}
+
+func (c *cartridge) Save() []uint8 {
+	save := make([]uint8, len(c.ram))
+	copy(save, c.ram)
+
+	return save
+}
+
+func (c *cartridge) Restore(save []uint8) error {
+	if len(save) < len(c.ram) {
+		return InvalidSaveErr
+	}
+
+	copy(c.ram, save)
+
+	return nil
+}
+
+func (c *cartridge) modified() bool {
+	d := c.dirty
+	c.dirty = false
+
+	return d
+}
+
+func (c *cartridge) touch() {
+	c.dirty = true
+}
+
+// Read a byte from a 16KiB ROM bank, where offset is relative to the start of the bank.
+// Bank numbers larger than the ROM wrap around.
+func (c *cartridge) readROM(bank int, offset uint16) uint8 {
+	bank %= len(c.rom) / romBankSize
+
+	return c.rom[bank*romBankSize+int(offset)]
+}
+
+// Read a byte from a 8KiB RAM bank, where offset is relative to the start of the bank.
+// If there is no RAM, 0xff is returned.
+func (c *cartridge) readRAM(bank int, offset uint16) uint8 {
+	if len(c.ram) == 0 {
+		return 0xff
+	}
+
+	return c.ram[(bank*ramBankSize+int(offset))%len(c.ram)]
+}
+
+// Write a byte to a 8KiB RAM bank, where offset is relative to the start of the bank.
+func (c *cartridge) writeRAM(bank int, offset uint16, val uint8) {
+	if len(c.ram) == 0 {
+		return
+	}
+
+	c.ram[(bank*ramBankSize+int(offset))%len(c.ram)] = val
+	c.dirty = true
+}
+
+// ROMOnly is a cartridge without a mapper, where 32KiB of ROM (and optionally 8KiB of RAM) is mapped directly.
+type ROMOnly struct {
+	cartridge
+}
+
+func (r *ROMOnly) Read(addr uint16) uint8 {
+	switch {
+
+	case addr <= 0x7fff:
+		return r.rom[addr]
+
+	case addr >= 0xa000 && addr <= 0xbfff:
+		return r.readRAM(0, addr-0xa000)
+
+	}
+
+	return 0xff
+}
+
+func (r *ROMOnly) Write(addr uint16, val uint8) {
+	if addr >= 0xa000 && addr <= 0xbfff {
+		r.writeRAM(0, addr-0xa000, val)
+	}
+}
@@ -0,0 +1,438 @@
+package tamago
+
+var (
+	// The waveforms for each duty cycle of the square channels (12.5%, 25%, 50% and 75%).
+	dutyCycles = [4][8]uint8{
+		{0, 0, 0, 0, 0, 0, 0, 1},
+		{1, 0, 0, 0, 0, 0, 0, 1},
+		{1, 0, 0, 0, 0, 1, 1, 1},
+		{0, 1, 1, 1, 1, 1, 1, 0},
+	}
+
+	// The base divisors of the noise channel, indexed by NR43 bits 0-2.
+	noiseDivisors = [8]int{8, 16, 32, 48, 64, 80, 96, 112}
+)
+
+// length turns off a channel once its counter reaches 0.
+// It is clocked at 256 Hz by the frame sequencer.
+type length struct {
+	max, counter int
+	enabled      bool
+}
+
+// Load the counter from a length register, where the counter counts up from v to max.
+func (l *length) load(v int) {
+	l.counter = l.max - v
+}
+
+// Clock the counter, clearing on if it expires.
+func (l *length) clock(on *bool) {
+	if l.enabled && l.counter > 0 {
+		l.counter--
+
+		if l.counter == 0 {
+			*on = false
+		}
+	}
+}
+
+func (l *length) trigger() {
+	if l.counter == 0 {
+		l.counter = l.max
+	}
+}
+
+// envelope raises or lowers a channel's volume periodically.
+// It is clocked at 64 Hz by the frame sequencer.
+type envelope struct {
+	initial, volume uint8
+	up              bool
+	pace, timer     uint8
+}
+
+// Write to the envelope register (NRx2).
+func (e *envelope) write(val uint8) {
+	e.initial = val >> 4
+	e.up = (val & 0x08) != 0
+	e.pace = val & 0x07
+}
+
+// An envelope pace of 0 is treated as 8 by the timer.
+func (e *envelope) reload() uint8 {
+	if e.pace == 0 {
+		return 8
+	}
+
+	return e.pace
+}
+
+func (e *envelope) clock() {
+	// The timer is 0 if the channel was never triggered, in which case it is reloaded straight away.
+	if e.timer > 0 {
+		e.timer--
+	}
+
+	if e.timer > 0 {
+		return
+	}
+
+	e.timer = e.reload()
+
+	if e.pace == 0 {
+		return
+	}
+
+	if e.up && e.volume < 15 {
+		e.volume++
+	} else if !e.up && e.volume > 0 {
+		e.volume--
+	}
+}
+
+func (e *envelope) trigger() {
+	e.volume = e.initial
+	e.timer = e.reload()
+}
+
+// square is a channel that outputs a square wave with an adjustable duty cycle.
+// Channel 1 also has a frequency sweep.
+// https://gbdev.io/pandocs/Audio_Registers.html#sound-channel-1--pulse-with-wavelength-sweep
+type square struct {
+	on, dac bool
+
+	length   length
+	envelope envelope
+
+	duty, pos uint8
+
+	freq  uint16
+	timer int
+
+	// Only used by channel 1.
+	hasSweep     bool
+	sweepPace    uint8
+	sweepDown    bool
+	sweepShift   uint8
+	sweepTimer   uint8
+	sweepEnabled bool
+	sweepFreq    uint16
+}
+
+func newSquare(hasSweep bool) *square {
+	return &square{length: length{max: 64}, hasSweep: hasSweep}
+}
+
+// Write to a register, where reg is 0 for NRx0 to 4 for NRx4.
+func (s *square) write(reg int, val uint8) {
+	switch reg {
+
+	case 0:
+		s.sweepPace = (val >> 4) & 0x07
+		s.sweepDown = (val & 0x08) != 0
+		s.sweepShift = val & 0x07
+
+	case 1:
+		s.duty = val >> 6
+		s.length.load(int(val & 0x3f))
+
+	case 2:
+		s.envelope.write(val)
+
+		// The DAC is off if the upper 5 bits are all 0, which also turns off the channel.
+		s.dac = (val & 0xf8) != 0
+		if !s.dac {
+			s.on = false
+		}
+
+	case 3:
+		s.freq = (s.freq & 0x700) | uint16(val)
+
+	case 4:
+		s.freq = (s.freq & 0xff) | uint16(val&0x07)<<8
+		s.length.enabled = (val & 0x40) != 0
+
+		if (val & 0x80) != 0 {
+			s.trigger()
+		}
+
+	}
+}
+
+func (s *square) trigger() {
+	s.on = s.dac
+	s.timer = s.period()
+
+	s.length.trigger()
+	s.envelope.trigger()
+
+	if s.hasSweep {
+		s.sweepFreq = s.freq
+		s.sweepTimer = s.sweepReload()
+		s.sweepEnabled = s.sweepPace != 0 || s.sweepShift != 0
+
+		if s.sweepShift != 0 {
+			s.sweep()
+		}
+	}
+}
+
+// Return the number of cycles between each step of the waveform.
+func (s *square) period() int {
+	return (2048 - int(s.freq)) * 4
+}
+
+func (s *square) step(cycles int) {
+	s.timer -= cycles
+
+	for s.timer <= 0 {
+		s.timer += s.period()
+		s.pos = (s.pos + 1) % 8
+	}
+}
+
+// Return the channel's digital output (0-15).
+func (s *square) output() uint8 {
+	if !s.on {
+		return 0
+	}
+
+	return dutyCycles[s.duty][s.pos] * s.envelope.volume
+}
+
+// A sweep pace of 0 is treated as 8 by the timer.
+func (s *square) sweepReload() uint8 {
+	if s.sweepPace == 0 {
+		return 8
+	}
+
+	return s.sweepPace
+}
+
+// Calculate the next frequency of the sweep, turning off the channel if it overflows.
+func (s *square) sweep() uint16 {
+	delta := s.sweepFreq >> s.sweepShift
+
+	var freq uint16
+	if s.sweepDown {
+		freq = s.sweepFreq - delta
+	} else {
+		freq = s.sweepFreq + delta
+	}
+
+	if freq > 2047 {
+		s.on = false
+	}
+
+	return freq
+}
+
+// Clock the sweep, which is done at 128 Hz by the frame sequencer.
+func (s *square) clockSweep() {
+	// The timer is 0 if the channel was never triggered, in which case it is reloaded straight away.
+	if s.sweepTimer > 0 {
+		s.sweepTimer--
+	}
+
+	if s.sweepTimer > 0 {
+		return
+	}
+
+	s.sweepTimer = s.sweepReload()
+
+	if s.sweepEnabled && s.sweepPace != 0 {
+		freq := s.sweep()
+
+		if freq <= 2047 && s.sweepShift != 0 {
+			s.sweepFreq = freq
+			s.freq = freq
+
+			// The overflow check is done again with the new frequency.
+			s.sweep()
+		}
+	}
+}
+
+// wave is a channel that plays back 32 4-bit samples from wave RAM.
+// https://gbdev.io/pandocs/Audio_Registers.html#sound-channel-3--wave-output
+type wave struct {
+	on, dac bool
+
+	length length
+
+	// The output level as a right shift of the sample (4 mutes the channel).
+	shift uint8
+
+	freq  uint16
+	timer int
+
+	pos    uint8
+	sample uint8
+
+	ram []uint8
+}
+
+func newWave(ram []uint8) *wave {
+	return &wave{length: length{max: 256}, shift: 4, ram: ram}
+}
+
+func (w *wave) write(reg int, val uint8) {
+	switch reg {
+
+	case 0:
+		w.dac = (val & 0x80) != 0
+		if !w.dac {
+			w.on = false
+		}
+
+	case 1:
+		w.length.load(int(val))
+
+	case 2:
+		// 0 is mute, 1 is 100%, 2 is 50% and 3 is 25%.
+		switch (val >> 5) & 0x03 {
+		case 0:
+			w.shift = 4
+		case 1:
+			w.shift = 0
+		case 2:
+			w.shift = 1
+		case 3:
+			w.shift = 2
+		}
+
+	case 3:
+		w.freq = (w.freq & 0x700) | uint16(val)
+
+	case 4:
+		w.freq = (w.freq & 0xff) | uint16(val&0x07)<<8
+		w.length.enabled = (val & 0x40) != 0
+
+		if (val & 0x80) != 0 {
+			w.trigger()
+		}
+
+	}
+}
+
+func (w *wave) trigger() {
+	w.on = w.dac
+	w.timer = w.period()
+	w.pos = 0
+
+	w.length.trigger()
+}
+
+func (w *wave) period() int {
+	return (2048 - int(w.freq)) * 2
+}
+
+func (w *wave) step(cycles int) {
+	w.timer -= cycles
+
+	for w.timer <= 0 {
+		w.timer += w.period()
+		w.pos = (w.pos + 1) % 32
+
+		// Each byte holds two samples, with the upper nibble played first.
+		b := w.ram[w.pos/2]
+		if w.pos%2 == 0 {
+			w.sample = b >> 4
+		} else {
+			w.sample = b & 0x0f
+		}
+	}
+}
+
+func (w *wave) output() uint8 {
+	if !w.on {
+		return 0
+	}
+
+	return w.sample >> w.shift
+}
+
+// noise is a channel that outputs pseudo-random noise from a linear feedback shift register.
+// https://gbdev.io/pandocs/Audio_Registers.html#sound-channel-4--noise
+type noise struct {
+	on, dac bool
+
+	length   length
+	envelope envelope
+
+	shift   uint8
+	narrow  bool
+	divisor uint8
+
+	timer int
+	lfsr  uint16
+}
+
+func newNoise() *noise {
+	return &noise{length: length{max: 64}, lfsr: 0x7fff}
+}
+
+func (n *noise) write(reg int, val uint8) {
+	switch reg {
+
+	case 1:
+		n.length.load(int(val & 0x3f))
+
+	case 2:
+		n.envelope.write(val)
+
+		n.dac = (val & 0xf8) != 0
+		if !n.dac {
+			n.on = false
+		}
+
+	case 3:
+		n.shift = val >> 4
+		n.narrow = (val & 0x08) != 0
+		n.divisor = val & 0x07
+
+	case 4:
+		n.length.enabled = (val & 0x40) != 0
+
+		if (val & 0x80) != 0 {
+			n.trigger()
+		}
+
+	}
+}
+
+func (n *noise) trigger() {
+	n.on = n.dac
+	n.timer = n.period()
+	n.lfsr = 0x7fff
+
+	n.length.trigger()
+	n.envelope.trigger()
+}
+
+func (n *noise) period() int {
+	return noiseDivisors[n.divisor] << n.shift
+}
+
+func (n *noise) step(cycles int) {
+	n.timer -= cycles
+
+	for n.timer <= 0 {
+		n.timer += n.period()
+
+		bit := (n.lfsr ^ (n.lfsr >> 1)) & 0x01
+		n.lfsr = (n.lfsr >> 1) | bit<<14
+
+		// In 7-bit mode, the result is also copied to bit 6.
+		if n.narrow {
+			n.lfsr = (n.lfsr &^ (1 << 6)) | bit<<6
+		}
+	}
+}
+
+func (n *noise) output() uint8 {
+	if !n.on {
+		return 0
+	}
+
+	return uint8(^n.lfsr&0x01) * n.envelope.volume
+}
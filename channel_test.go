@@ -0,0 +1,57 @@
+package tamago
+
+import "testing"
+
+func TestEnvelopeZeroPace(t *testing.T) {
+	tests := []struct {
+		name    string
+		trigger bool
+
+		// The clocks until the volume first changes.
+		clocks int
+	}{
+		// The timer was loaded with 8 (for a pace of 0) when triggered.
+		{"triggered with pace 0", true, 8},
+		// The timer was never loaded, so it is reloaded on the first clock.
+		{"never triggered", false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e envelope
+			e.write(0xf0)
+			if tt.trigger {
+				e.trigger()
+			}
+			e.volume = 15
+
+			// Set the pace without retriggering.
+			e.write(0xf1)
+
+			for i := 1; i < tt.clocks; i++ {
+				e.clock()
+
+				if e.volume != 15 {
+					t.Fatalf("volume changed to %d after %d clocks, expected it to wait for %d", e.volume, i, tt.clocks)
+				}
+			}
+
+			e.clock()
+
+			if e.volume != 14 {
+				t.Errorf("volume is %d after %d clocks, expected 14", e.volume, tt.clocks)
+			}
+		})
+	}
+}
+
+func TestSweepNeverTriggered(t *testing.T) {
+	s := newSquare(true)
+	s.write(0, 0x11)
+
+	s.clockSweep()
+
+	if s.sweepTimer != 1 {
+		t.Errorf("sweep timer is %d after clocking, expected it to be reloaded with the pace", s.sweepTimer)
+	}
+}
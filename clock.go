@@ -1,8 +1,12 @@
 package tamago
 
 // Clock keeps track of how many transistor cycles have run so far.
+// Cycles are counted at normal speed, so they are the same length in double speed mode.
 type Clock struct {
-	t int
+	t, last int
+
+	// In CGB double speed mode, each machine cycle only takes 2 transistor cycles at normal speed.
+	double bool
 }
 
 func NewClock() *Clock {
@@ -11,10 +15,23 @@ func NewClock() *Clock {
 
 // step forward the clock by m machine cycles.
 func (c *Clock) step(m int) {
-	c.t += m * 4
+	if c.double {
+		c.t += m * 2
+	} else {
+		c.t += m * 4
+	}
+}
+
+// Return the number of transistor cycles run since the last call to delta.
+func (c *Clock) delta() int {
+	d := c.t - c.last
+	c.last = c.t
+
+	return d
 }
 
 // Reset the clock to 0.
 func (c *Clock) Reset() {
 	c.t = 0
+	c.last = 0
 }
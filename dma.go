@@ -0,0 +1,85 @@
+package tamago
+
+const dmaLength = 0xa0
+
+// oamDMA copies 160 bytes from anywhere in memory to OAM, one byte per machine cycle.
+// While the transfer is running, the CPU can't use the bus the source is on, so it should run from HRAM.
+// https://gbdev.io/pandocs/OAM_DMA_Transfer.html
+type oamDMA struct {
+	reg    uint8
+	source uint16
+
+	active bool
+	index  uint16
+
+	// The byte currently being transferred, which is what the CPU sees on the same bus.
+	value uint8
+
+	cycles int
+}
+
+// Start a transfer, where the source address is val * 0x100.
+func (d *oamDMA) start(val uint8) {
+	d.reg = val
+	d.source = uint16(val) << 8
+
+	// 0xe000 and above map to work RAM (like echo RAM) instead of OAM and I/O.
+	if d.source >= 0xe000 {
+		d.source -= 0x2000
+	}
+
+	d.active = true
+	d.index = 0
+	d.cycles = 0
+}
+
+// Check if the CPU is blocked from accessing addr by the transfer.
+func (d *oamDMA) blocks(addr uint16) bool {
+	if !d.active {
+		return false
+	}
+
+	switch {
+
+	// OAM is being written to by the transfer.
+	case addr >= 0xfe00 && addr <= 0xfeff:
+		return true
+
+	// I/O and HRAM are always accessible.
+	case addr >= 0xff00:
+		return false
+
+	}
+
+	// VRAM is on its own bus, and everything else is on the external bus.
+	return isVRAM(addr) == isVRAM(d.source)
+}
+
+func isVRAM(addr uint16) bool {
+	return addr >= 0x8000 && addr <= 0x9fff
+}
+
+// Given the number of cycles run, copy as many bytes to OAM as fit.
+func (m *MMU) stepDMA(cycles int) {
+	d := &m.dma
+
+	if !d.active {
+		return
+	}
+
+	d.cycles += cycles
+
+	for d.cycles >= 4 && d.index < dmaLength {
+		d.cycles -= 4
+
+		d.value = m.read(d.source + d.index)
+		m.oam[d.index] = d.value
+		m.render.updateSprite(d.index)
+
+		d.index++
+	}
+
+	if d.index == dmaLength {
+		d.active = false
+	}
+}
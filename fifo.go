@@ -0,0 +1,323 @@
+package tamago
+
+import (
+	"sort"
+)
+
+// States of the pixel fetcher, each taking 2 dots (except for pushing).
+const (
+	fetchTile = iota
+	fetchLow
+	fetchHigh
+	fetchPush
+)
+
+// The number of dots taken to fetch a sprite's tile data.
+const spriteFetchDots = 6
+
+// pixel is a pixel waiting in a FIFO to be pushed to the LCD.
+type pixel struct {
+	// The colour index (0-3), where 0 is transparent for sprites.
+	colour uint8
+
+	palette *Palette
+
+	// The priority bit of the tile's attributes or the sprite's options.
+	bgPriority bool
+
+	// The index of the sprite in OAM, used for sprite priority in CGB mode.
+	sprite int
+}
+
+// pixelFIFO draws scanlines pixel by pixel during mode 3, like the hardware's pixel fetcher and FIFOs.
+// Unlike drawing the whole scanline at once, the length of mode 3 depends on the fine scroll, window and sprites,
+// and writes to registers such as SCX, the palettes and LCDC take effect from the next pixel.
+// https://gbdev.io/pandocs/pixel_fifo.html
+type pixelFIFO struct {
+	r *Render
+
+	// The FIFOs are slices of fixed buffers, so they don't have to be reallocated.
+	bg, obj       []pixel
+	bgBuf, objBuf [16]pixel
+
+	// The fetcher's state, the dots spent in that state, and the tile column it is fetching.
+	state, dots int
+	tileX       uint8
+
+	// The tile index, attributes and row fetched so far.
+	tile uint8
+	attr Bits
+	row  [8]uint8
+
+	// The first tile fetched on each scanline is thrown away.
+	first bool
+
+	// True once the fetcher has switched to the window on this scanline.
+	window bool
+
+	// The x coordinate of the next pixel pushed to the LCD.
+	x int
+
+	// The number of pixels left to throw away before pushing to the LCD (due to SCX or WX < 7).
+	discard int
+
+	// The sprites on this scanline in order of priority, and the index of the next one to fetch.
+	sprites []int
+	next    int
+
+	// The sprite being fetched (-1 if none), and the dots spent fetching it.
+	sprite     int
+	spriteDots int
+
+	// The number of dots spent in mode 3 so far.
+	length int
+	done   bool
+}
+
+func newPixelFIFO(r *Render) *pixelFIFO {
+	return &pixelFIFO{r: r}
+}
+
+// Start drawing the current scanline.
+func (f *pixelFIFO) start() {
+	f.bg = f.bgBuf[:0]
+	f.obj = f.objBuf[:0]
+
+	f.state = fetchTile
+	f.dots = 0
+	f.tileX = 0
+	f.first = true
+	f.window = false
+
+	f.x = 0
+	f.discard = int(f.r.sx % 8)
+
+	f.sprites = f.sprites[:0]
+	if f.r.lcdc.At(1) {
+		f.sprites = f.r.scanSprites()
+
+		// Sprites are fetched from left to right, regardless of priority.
+		sort.SliceStable(f.sprites, func(a, b int) bool {
+			return f.r.spriteData[f.sprites[a]].x < f.r.spriteData[f.sprites[b]].x
+		})
+	}
+	f.next = 0
+	f.sprite = -1
+
+	f.length = 0
+	f.done = false
+}
+
+// Run the fetcher and FIFOs for a single dot.
+func (f *pixelFIFO) tick() {
+	f.length++
+
+	if f.sprite < 0 {
+		f.checkSprite()
+	}
+
+	if f.sprite >= 0 {
+		// Sprite fetches wait until the background fetcher has a tile ready to push, pausing the FIFOs.
+		if f.state != fetchPush {
+			f.fetch()
+			return
+		}
+
+		f.spriteDots++
+		if f.spriteDots == spriteFetchDots {
+			f.fetchSprite()
+		}
+
+		return
+	}
+
+	f.fetch()
+	f.shift()
+}
+
+// Check if a sprite starts at the current pixel, and if so start fetching it.
+func (f *pixelFIFO) checkSprite() {
+	if f.next >= len(f.sprites) || !f.r.lcdc.At(1) {
+		return
+	}
+
+	i := f.sprites[f.next]
+
+	// Sprites partially off the left of the screen are fetched at the first pixel.
+	sx := int(f.r.spriteData[i].x) - 8
+	if sx <= f.x && f.x < renderWidth {
+		f.sprite = i
+		f.spriteDots = 0
+		f.next++
+	}
+}
+
+// Fetch the sprite's tile data and merge it into the sprite FIFO.
+func (f *pixelFIFO) fetchSprite() {
+	sprite := &f.r.spriteData[f.sprite]
+	row := f.r.spriteRow(sprite)
+	p := f.r.objPalette(sprite)
+
+	// Pixels off the left of the screen are thrown away.
+	start := f.x - (int(sprite.x) - 8)
+
+	// Move the remaining pixels to the start of the buffer, and fill the rest with transparent pixels.
+	f.obj = f.objBuf[:copy(f.objBuf[:], f.obj)]
+	for len(f.obj) < 8 {
+		f.obj = append(f.obj, pixel{})
+	}
+
+	for i := start; i < 8; i++ {
+		// On the DMG, sprites fetched earlier have priority, so only transparent pixels are replaced.
+		// In CGB mode, sprites earlier in OAM have priority instead.
+		slot := &f.obj[i-start]
+		if row[i] != 0 && (slot.colour == 0 || (f.r.cgb && f.sprite < slot.sprite)) {
+			*slot = pixel{
+				colour:     row[i],
+				palette:    p,
+				bgPriority: sprite.options.At(7),
+				sprite:     f.sprite,
+			}
+		}
+	}
+
+	f.sprite = -1
+}
+
+// Run the background/window fetcher for one dot.
+func (f *pixelFIFO) fetch() {
+	if f.state == fetchPush {
+		f.push()
+		return
+	}
+
+	f.dots++
+	if f.dots < 2 {
+		return
+	}
+	f.dots = 0
+
+	switch f.state {
+
+	case fetchTile:
+		r := f.r
+
+		var offset uint16
+		if f.window {
+			offset = r.tileMap(6) + uint16(r.windowLine/8)*32 + uint16(f.tileX&0x1f)
+		} else {
+			y := r.sy + r.line
+			x := r.sx/8 + f.tileX
+			offset = r.tileMap(3) + uint16(y/8)*32 + uint16(x&0x1f)
+		}
+
+		f.tile = r.vram[offset]
+		f.attr = r.attributes(offset)
+
+	case fetchHigh:
+		r := f.r
+
+		y := r.sy + r.line
+		if f.window {
+			y = r.windowLine
+		}
+
+		f.row = r.tileRow(f.tile, f.attr, y%8)
+
+	}
+
+	f.state++
+
+	// The tile is pushed straight away if the FIFO is empty.
+	if f.state == fetchPush {
+		f.push()
+	}
+}
+
+// Push the fetched tile row to the background FIFO, if it is empty.
+func (f *pixelFIFO) push() {
+	if len(f.bg) > 0 {
+		return
+	}
+
+	f.state = fetchTile
+
+	if f.first {
+		f.first = false
+		return
+	}
+
+	f.bg = f.bgBuf[:0]
+
+	// On the DMG, the background and window are blank if LCDC bit 0 is clear.
+	enabled := f.r.lcdc.At(0) || f.r.cgb
+	p := f.r.bgPalette(f.attr)
+
+	for _, c := range f.row {
+		if !enabled {
+			c = 0
+		}
+
+		f.bg = append(f.bg, pixel{colour: c, palette: p, bgPriority: f.attr.At(7)})
+	}
+
+	f.tileX++
+}
+
+// Shift a pixel out of the FIFOs to the LCD.
+func (f *pixelFIFO) shift() {
+	if len(f.bg) == 0 {
+		return
+	}
+
+	r := f.r
+
+	// Switch to fetching the window once its left edge is reached.
+	// This clears the background FIFO and restarts the fetcher.
+	if !f.window && r.lcdc.At(5) && r.windowY && f.x >= int(r.wx)-7 {
+		f.window = true
+		f.bg = f.bgBuf[:0]
+		f.state = fetchTile
+		f.dots = 0
+		f.tileX = 0
+
+		// When WX < 7, the leftmost pixels of the window are cut off.
+		if r.wx < 7 {
+			f.discard = 7 - int(r.wx)
+		} else {
+			f.discard = 0
+		}
+
+		return
+	}
+
+	bg := f.bg[0]
+	f.bg = f.bg[1:]
+
+	if f.discard > 0 {
+		f.discard--
+		return
+	}
+
+	colour := &bg.palette[bg.colour]
+
+	if len(f.obj) > 0 {
+		obj := f.obj[0]
+		f.obj = f.obj[1:]
+
+		if obj.colour != 0 && r.lcdc.At(1) && !r.bgOver(bg.colour, bg.bgPriority, obj.bgPriority) {
+			colour = &obj.palette[obj.colour]
+		}
+	}
+
+	r.draw(f.x, int(r.line), colour)
+
+	f.x++
+	if f.x == renderWidth {
+		f.done = true
+
+		if f.window {
+			r.windowLine++
+		}
+	}
+}
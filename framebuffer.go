@@ -8,9 +8,13 @@ import (
 )
 
 // Framebuffer provides a efficient way to manipulate pixels.
+// Pixels are drawn to a back buffer, and complete frames are published to a front buffer to be presented.
 type Framebuffer struct {
 	width, height int
 	pixels        []byte
+
+	front []byte
+	ready bool
 }
 
 // Create a new framebuffer.
@@ -24,6 +28,7 @@ func NewFramebuffer(width, height int) *Framebuffer {
 		width:  width,
 		height: height,
 		pixels: make([]byte, width*height*4),
+		front:  make([]byte, width*height*4),
 	}
 }
 
@@ -35,9 +40,9 @@ func (fb *Framebuffer) Size() int {
 // Write the colour c at the position (x, y).
 // 0 <= x < width and 0 <= y < height must be true or a panic will occur.
 func (fb *Framebuffer) Write(x, y int, c *color.RGBA) {
-	index := x * y * 4
+	index := (y*fb.width + x) * 4
 
-	if index > fb.Size() || index < 0 {
+	if index >= fb.Size() || index < 0 {
 		panic(fmt.Sprintf("index (%d, %d) out of bounds!", x, y))
 	}
 
@@ -49,9 +54,9 @@ func (fb *Framebuffer) Write(x, y int, c *color.RGBA) {
 
 // Read the colour at the position (x, y).
 func (fb *Framebuffer) Read(x, y int) *color.RGBA {
-	index := x * y * 4
+	index := (y*fb.width + x) * 4
 
-	if index > fb.Size() || index < 0 {
+	if index >= fb.Size() || index < 0 {
 		panic(fmt.Sprintf("index (%d, %d) out of bounds!", x, y))
 	}
 
@@ -63,6 +68,34 @@ func (fb *Framebuffer) Read(x, y int) *color.RGBA {
 	}
 }
 
+// Fill the whole framebuffer with the colour c.
+func (fb *Framebuffer) Clear(c *color.RGBA) {
+	for i := 0; i < fb.Size(); i += 4 {
+		fb.pixels[i] = c.R
+		fb.pixels[i+1] = c.G
+		fb.pixels[i+2] = c.B
+		fb.pixels[i+3] = c.A
+	}
+}
+
+// Publish the pixels drawn so far as a complete frame.
+func (fb *Framebuffer) Publish() {
+	copy(fb.front, fb.pixels)
+	fb.ready = true
+}
+
+// Copy the latest published frame into a screen, if a new frame has been published since the last call.
+func (fb *Framebuffer) Present(screen *ebiten.Image) bool {
+	if !fb.ready {
+		return false
+	}
+
+	screen.ReplacePixels(fb.front)
+	fb.ready = false
+
+	return true
+}
+
 // Copy the contents of the framebuffer into a screen.
 func (fb *Framebuffer) CopyInto(screen *ebiten.Image) {
 	screen.ReplacePixels(fb.pixels)
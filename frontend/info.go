@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ongyx/tamago"
+)
+
+var FailedErr = errors.New("some ROMs could not be read")
+
+// report is the result of inspecting a single ROM file.
+type report struct {
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+
+	*tamago.RomInfo
+
+	Licensee  string `json:"licensee,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Mapper    string `json:"mapper,omitempty"`
+	Supported bool   `json:"supported"`
+}
+
+func inspect(path string) *report {
+	r := &report{Path: path}
+
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	info, err := tamago.ParseHeader(rom)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	r.RomInfo = info
+	r.Licensee = info.Licensee()
+	r.Type = info.Type()
+	r.Mapper = info.Mapper()
+
+	_, err = tamago.NewCartridge(rom, info)
+	r.Supported = err == nil
+
+	return r
+}
+
+func valid(b bool) string {
+	if b {
+		return "valid"
+	}
+
+	return "invalid"
+}
+
+func (r *report) print(w *tabwriter.Writer) {
+	fmt.Fprintf(w, "%s\n", r.Path)
+
+	if r.Error != "" {
+		fmt.Fprintf(w, "  error:\t%s\n", r.Error)
+		return
+	}
+
+	fmt.Fprintf(w, "  title:\t%s\n", r.Title)
+	fmt.Fprintf(w, "  manufacturer:\t%s\n", r.Manufacturer)
+	fmt.Fprintf(w, "  licensee:\t%s\n", r.Licensee)
+	fmt.Fprintf(w, "  cgb flag:\t0x%02x\n", r.CGBFlag)
+	fmt.Fprintf(w, "  sgb flag:\t0x%02x\n", r.SGBFlag)
+	fmt.Fprintf(w, "  cartridge type:\t0x%02x (%s)\n", r.CartridgeType, r.Type)
+	fmt.Fprintf(w, "  mapper:\t%s (supported: %t)\n", r.Mapper, r.Supported)
+	fmt.Fprintf(w, "  rom size:\t%d KiB\n", r.ROMSize/1024)
+	fmt.Fprintf(w, "  ram size:\t%d KiB\n", r.RAMSize/1024)
+	fmt.Fprintf(w, "  destination:\t0x%02x\n", r.Destination)
+	fmt.Fprintf(w, "  version:\t%d\n", r.Version)
+	fmt.Fprintf(w, "  nintendo logo:\t%s\n", valid(r.LogoValid))
+	fmt.Fprintf(w, "  header checksum:\t0x%02x (%s)\n", r.HeaderChecksum, valid(r.HeaderChecksumValid))
+	fmt.Fprintf(w, "  global checksum:\t0x%04x (%s)\n", r.GlobalChecksum, valid(r.GlobalChecksumValid))
+}
+
+// Run the info command, which prints the headers of one or more ROM files.
+func info(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the headers as a JSON array")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s info [-json] rom...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var failed bool
+
+	reports := make([]*report, fs.NArg())
+	for i, path := range fs.Args() {
+		reports[i] = inspect(path)
+		failed = failed || reports[i].Error != ""
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+
+		for _, r := range reports {
+			r.print(w)
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if failed {
+		return FailedErr
+	}
+
+	return nil
+}
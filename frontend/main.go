@@ -3,38 +3,139 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/audio"
 
 	"github.com/ongyx/tamago"
 )
 
 var (
-	rom, bootrom string
+	rom, bootrom, ppu  string
+	wallclock, lenient bool
+
+	linkNetwork, linkListen, linkConnect string
+	printer                              string
 )
 
 func init() {
 	flag.StringVar(&rom, "rom", "", "rom file")
 	flag.StringVar(&bootrom, "bootrom", "", "bootrom file")
+	flag.StringVar(&ppu, "ppu", "scanline", "how the PPU draws scanlines (scanline or fifo)")
+	flag.BoolVar(&lenient, "lenient", false, "allow access to VRAM and OAM while the PPU is using them")
+	flag.BoolVar(&wallclock, "wallclock", false, "run the cartridge's real time clock (if any) from the host's clock")
+	flag.StringVar(&linkNetwork, "link-network", "tcp", "network of the link cable connection (tcp or unix)")
+	flag.StringVar(&linkListen, "link-listen", "", "wait for another instance to connect a link cable on this address")
+	flag.StringVar(&linkConnect, "link-connect", "", "connect a link cable to another instance listening on this address")
+	flag.StringVar(&printer, "printer", "", "connect a Game Boy Printer, writing printed images to this directory")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "info" {
+		if err := info(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	game := tamago.NewGame()
 
 	flag.Parse()
 
+	switch ppu {
+	case "scanline":
+		game.S.SetRenderer(tamago.ScanlineRenderer)
+	case "fifo":
+		game.S.SetRenderer(tamago.FIFORenderer)
+	default:
+		fmt.Printf("invalid ppu %q, expected scanline or fifo\n", ppu)
+		os.Exit(2)
+	}
+
+	game.S.SetLenient(lenient)
+
 	if bootrom != "" {
-		game.S.LoadBoot(bootrom)
+		if err := game.S.LoadBoot(bootrom); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}
 
 	if rom != "" {
-		game.S.Load(rom)
+		if err := game.S.Load(rom); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if c, ok := game.S.Cartridge().(*tamago.MBC3); ok && c.RTC != nil {
+			c.RTC.SetWallClock(wallclock)
+		}
+	}
+
+	switch {
+
+	case printer != "" && (linkListen != "" || linkConnect != ""):
+		fmt.Println("only one of a link cable or printer can be connected")
+		os.Exit(2)
+
+	case linkListen != "" && linkConnect != "":
+		fmt.Println("only one of -link-listen or -link-connect can be given")
+		os.Exit(2)
+
+	case printer != "":
+		p, err := tamago.NewPrinter(printer)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		game.S.SetSerialDevice(p)
+
+	case linkListen != "" || linkConnect != "":
+		link, err := connectLink()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer link.Close()
+
+		game.S.SetSerialDevice(link)
+
+	}
+
+	title := "tamago"
+	if info := game.S.Info(); info != nil && info.Title != "" {
+		title += " - " + info.Title
+	}
+
+	player, err := audio.NewPlayer(audio.NewContext(tamago.SampleRate), game.S.APU())
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		player.Play()
 	}
 
 	ebiten.SetWindowSize(256, 256)
-	ebiten.SetWindowTitle("tamago")
+	ebiten.SetWindowTitle(title)
 	if err := ebiten.RunGame(game); err != nil {
 		fmt.Println(err)
 	}
 
+	if err := game.Close(); err != nil {
+		fmt.Println(err)
+	}
+
+}
+
+// Connect a link cable to another instance, by either listening or connecting.
+func connectLink() (*tamago.Link, error) {
+	if linkListen != "" {
+		fmt.Printf("waiting for link cable connection on %s\n", linkListen)
+		return tamago.ListenLink(linkNetwork, linkListen)
+	}
+
+	return tamago.DialLink(linkNetwork, linkConnect)
 }
@@ -29,6 +29,26 @@ func (g *Game) Draw(screen *ebiten.Image) {
 	g.S.Update(screen)
 }
 
+// Export the save file of the loaded cartridge.
+func (g *Game) ExportSave() []uint8 {
+	return g.S.ExportSave()
+}
+
+// Import a save file into the loaded cartridge.
+func (g *Game) ImportSave(save []uint8) error {
+	return g.S.ImportSave(save)
+}
+
+// Close the game, flushing battery-backed RAM to the save file.
+func (g *Game) Close() error {
+	return g.S.Flush()
+}
+
 func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
+	// In SGB mode, the border surrounds the screen.
+	if g.S.SGB() != nil {
+		return sgbWidth, sgbHeight
+	}
+
 	return renderWidth, renderHeight
 }
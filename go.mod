@@ -2,4 +2,4 @@ module github.com/ongyx/tamago
 
 go 1.16
 
-require github.com/hajimehoshi/ebiten/v2 v2.1.6 // indirect
+require github.com/hajimehoshi/ebiten/v2 v2.1.6
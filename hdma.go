@@ -0,0 +1,107 @@
+package tamago
+
+// The number of bytes copied by VRAM DMA at once.
+const hdmaBlock = 0x10
+
+// hdma copies data to VRAM in blocks of 16 bytes, in CGB mode only.
+// General purpose DMA copies everything at once, while HBlank DMA copies one block at the start of each HBlank.
+// The CPU is halted while each block is copied.
+// https://gbdev.io/pandocs/CGB_Registers.html#lcd-vram-dma-transfers
+type hdma struct {
+	source, dest uint16
+
+	// The number of blocks left to copy minus 1, as read from HDMA5.
+	length uint8
+
+	// True while a HBlank DMA is running.
+	active bool
+
+	// The number of machine cycles the CPU has to be halted for.
+	stall int
+}
+
+// Return the value of HDMA5, where bit 7 is clear while a HBlank DMA is running.
+func (h *hdma) status() uint8 {
+	return tobit(!h.active)<<7 | h.length
+}
+
+// Write to one of the HDMA1-HDMA5 registers.
+func (m *MMU) writeHDMA(addr uint16, val uint8) {
+	h := &m.hdma
+
+	switch addr {
+
+	case 0xff51:
+		h.source = uint16(val)<<8 | (h.source & 0x00ff)
+
+	// The lower 4 bits of the addresses are ignored.
+	case 0xff52:
+		h.source = (h.source & 0xff00) | uint16(val&0xf0)
+
+	// The destination is always in VRAM.
+	case 0xff53:
+		h.dest = uint16(val&0x1f)<<8 | (h.dest & 0x00ff)
+
+	case 0xff54:
+		h.dest = (h.dest & 0xff00) | uint16(val&0xf0)
+
+	case 0xff55:
+		// Writing with bit 7 clear while a HBlank DMA is running cancels it.
+		if h.active && (val&0x80) == 0 {
+			h.active = false
+			return
+		}
+
+		h.length = val & 0x7f
+
+		if (val & 0x80) != 0 {
+			h.active = true
+			return
+		}
+
+		// A general purpose DMA copies all the blocks straight away.
+		for !m.copyHDMA() {
+		}
+
+	}
+}
+
+// Copy the next block if a HBlank DMA is running, which is done at the start of each HBlank.
+func (m *MMU) hblankDMA() {
+	if m.hdma.active {
+		m.hdma.active = !m.copyHDMA()
+	}
+}
+
+// Copy a block to VRAM, returning true if it was the last one.
+func (m *MMU) copyHDMA() bool {
+	h := &m.hdma
+
+	for i := 0; i < hdmaBlock; i++ {
+		// The destination wraps around within VRAM, and is written to the current VRAM bank.
+		m.write(0x8000|(h.dest&0x1fff), m.read(h.source))
+
+		h.source++
+		h.dest++
+	}
+
+	// Each block takes 8 machine cycles at normal speed, and 16 in double speed mode.
+	if m.double {
+		h.stall += 16
+	} else {
+		h.stall += 8
+	}
+
+	// Once the last block is copied, the length wraps around so HDMA5 reads 0xff.
+	h.length = (h.length - 1) & 0x7f
+
+	return h.length == 0x7f
+}
+
+// Return the number of machine cycles the CPU has been halted for by VRAM DMA since the last call.
+func (m *MMU) hdmaStall() int {
+	stall := m.hdma.stall
+	m.hdma.stall = 0
+
+	return stall
+}
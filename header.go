@@ -0,0 +1,217 @@
+package tamago
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The end of the header, so ROMs smaller than this can't be parsed.
+const headerEnd = 0x150
+
+var (
+	NoHeaderErr = errors.New("ROM is too small to have a header")
+
+	// The Nintendo logo at 0x104-0x133, which the bootrom checks before running the game.
+	nintendoLogo = [...]uint8{
+		0xce, 0xed, 0x66, 0x66, 0xcc, 0x0d, 0x00, 0x0b, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0c, 0x00, 0x0d,
+		0x00, 0x08, 0x11, 0x1f, 0x88, 0x89, 0x00, 0x0e, 0xdc, 0xcc, 0x6e, 0xe6, 0xdd, 0xdd, 0xd9, 0x99,
+		0xbb, 0xbb, 0x67, 0x63, 0x6e, 0x0e, 0xec, 0xcc, 0xdd, 0xdc, 0x99, 0x9f, 0xbb, 0xb9, 0x33, 0x3e,
+	}
+
+	// The mapper and full name of each cartridge type.
+	// https://gbdev.io/pandocs/The_Cartridge_Header.html#0147---cartridge-type
+	cartridgeTypes = map[uint8]struct{ mapper, name string }{
+		0x00: {"none", "ROM ONLY"},
+		0x01: {"MBC1", "MBC1"},
+		0x02: {"MBC1", "MBC1+RAM"},
+		0x03: {"MBC1", "MBC1+RAM+BATTERY"},
+		0x05: {"MBC2", "MBC2"},
+		0x06: {"MBC2", "MBC2+BATTERY"},
+		0x08: {"none", "ROM+RAM"},
+		0x09: {"none", "ROM+RAM+BATTERY"},
+		0x0b: {"MMM01", "MMM01"},
+		0x0c: {"MMM01", "MMM01+RAM"},
+		0x0d: {"MMM01", "MMM01+RAM+BATTERY"},
+		0x0f: {"MBC3", "MBC3+TIMER+BATTERY"},
+		0x10: {"MBC3", "MBC3+TIMER+RAM+BATTERY"},
+		0x11: {"MBC3", "MBC3"},
+		0x12: {"MBC3", "MBC3+RAM"},
+		0x13: {"MBC3", "MBC3+RAM+BATTERY"},
+		0x19: {"MBC5", "MBC5"},
+		0x1a: {"MBC5", "MBC5+RAM"},
+		0x1b: {"MBC5", "MBC5+RAM+BATTERY"},
+		0x1c: {"MBC5", "MBC5+RUMBLE"},
+		0x1d: {"MBC5", "MBC5+RUMBLE+RAM"},
+		0x1e: {"MBC5", "MBC5+RUMBLE+RAM+BATTERY"},
+		0x20: {"MBC6", "MBC6"},
+		0x22: {"MBC7", "MBC7+SENSOR+RUMBLE+RAM+BATTERY"},
+		0xfc: {"POCKET CAMERA", "POCKET CAMERA"},
+		0xfd: {"TAMA5", "BANDAI TAMA5"},
+		0xfe: {"HuC3", "HuC3"},
+		0xff: {"HuC1", "HuC1+RAM+BATTERY"},
+	}
+)
+
+// RomInfo is the cartridge header at 0x100-0x14f of a ROM.
+// https://gbdev.io/pandocs/The_Cartridge_Header.html
+type RomInfo struct {
+	// The game's title in uppercase ASCII.
+	Title string `json:"title"`
+
+	// A 4-character code found in newer cartridges only, and empty otherwise.
+	Manufacturer string `json:"manufacturer"`
+
+	// 0x80 if the game supports CGB enhancements, or 0xc0 if it only works on a CGB.
+	CGBFlag uint8 `json:"cgb_flag"`
+
+	// 0x03 if the game supports SGB functions.
+	SGBFlag uint8 `json:"sgb_flag"`
+
+	// If the old licensee code is 0x33, the new licensee code is used instead.
+	OldLicensee uint8  `json:"old_licensee"`
+	NewLicensee string `json:"new_licensee"`
+
+	// The mapper and other hardware on the cartridge.
+	CartridgeType uint8 `json:"cartridge_type"`
+
+	// The size of ROM and external RAM in bytes.
+	ROMSize int `json:"rom_size"`
+	RAMSize int `json:"ram_size"`
+
+	// 0x00 if the game is sold in Japan, and 0x01 otherwise.
+	Destination uint8 `json:"destination"`
+
+	Version uint8 `json:"version"`
+
+	// The bootrom refuses to run the game if the Nintendo logo doesn't match.
+	LogoValid bool `json:"logo_valid"`
+
+	// The header checksum is calculated over 0x134-0x14c, and the global checksum over the whole ROM (except itself).
+	// Only the header checksum is verified by the bootrom.
+	HeaderChecksum      uint8  `json:"header_checksum"`
+	HeaderChecksumValid bool   `json:"header_checksum_valid"`
+	GlobalChecksum      uint16 `json:"global_checksum"`
+	GlobalChecksumValid bool   `json:"global_checksum_valid"`
+}
+
+// Parse the header of a ROM.
+func ParseHeader(rom []uint8) (*RomInfo, error) {
+	if len(rom) < headerEnd {
+		return nil, NoHeaderErr
+	}
+
+	info := &RomInfo{
+		CGBFlag:        rom[0x143],
+		SGBFlag:        rom[0x146],
+		OldLicensee:    rom[0x14b],
+		CartridgeType:  rom[0x147],
+		RAMSize:        ramSizes[rom[0x149]],
+		Destination:    rom[0x14a],
+		Version:        rom[0x14c],
+		HeaderChecksum: rom[0x14d],
+		GlobalChecksum: uint16(rom[0x14e])<<8 | uint16(rom[0x14f]),
+	}
+
+	// Older cartridges use 0x134-0x143 for the title, but the CGB flag and manufacturer code were carved out of it later.
+	title := rom[0x134:0x144]
+	if info.CGB() {
+		title = rom[0x134:0x143]
+
+		if code := rom[0x13f:0x143]; isCode(code) {
+			info.Manufacturer = string(code)
+			title = rom[0x134:0x13f]
+		}
+	}
+	info.Title = cstring(title)
+
+	if info.OldLicensee == 0x33 {
+		info.NewLicensee = cstring(rom[0x144:0x146])
+	}
+
+	// The ROM size is 32KiB shifted left by the size byte.
+	if size := rom[0x148]; size <= 0x08 {
+		info.ROMSize = (romBankSize * 2) << size
+	}
+
+	info.LogoValid = string(rom[0x104:0x134]) == string(nintendoLogo[:])
+
+	var hsum uint8
+	for _, b := range rom[0x134:0x14d] {
+		hsum = hsum - b - 1
+	}
+	info.HeaderChecksumValid = hsum == info.HeaderChecksum
+
+	var gsum uint16
+	for i, b := range rom {
+		if i != 0x14e && i != 0x14f {
+			gsum += uint16(b)
+		}
+	}
+	info.GlobalChecksumValid = gsum == info.GlobalChecksum
+
+	return info, nil
+}
+
+// Check if the game supports CGB enhancements.
+func (ri *RomInfo) CGB() bool {
+	return (ri.CGBFlag & 0x80) != 0
+}
+
+// Check if the game supports SGB functions.
+// The old licensee code must also be 0x33 for the SGB flag to be recognised.
+func (ri *RomInfo) SGB() bool {
+	return ri.SGBFlag == 0x03 && ri.OldLicensee == 0x33
+}
+
+// Return the name of the cartridge's mapper ("none" if it doesn't have one).
+func (ri *RomInfo) Mapper() string {
+	if t, ok := cartridgeTypes[ri.CartridgeType]; ok {
+		return t.mapper
+	}
+
+	return "unknown"
+}
+
+// Return the full name of the cartridge type, including any extra hardware.
+func (ri *RomInfo) Type() string {
+	if t, ok := cartridgeTypes[ri.CartridgeType]; ok {
+		return t.name
+	}
+
+	return "unknown"
+}
+
+// Check if the cartridge has battery-backed RAM.
+func (ri *RomInfo) Battery() bool {
+	return batteryTypes[ri.CartridgeType]
+}
+
+// Return the licensee code as a string, whichever of the old or new code is used.
+func (ri *RomInfo) Licensee() string {
+	if ri.OldLicensee == 0x33 {
+		return ri.NewLicensee
+	}
+
+	return fmt.Sprintf("%02X", ri.OldLicensee)
+}
+
+// Convert a null-padded byte string to a Go string, trimming any trailing spaces.
+func cstring(b []uint8) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+
+	return strings.TrimRight(string(b), " ")
+}
+
+// Check if all bytes are uppercase letters or digits, as used in manufacturer codes.
+func isCode(b []uint8) bool {
+	for _, c := range b {
+		if !(('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')) {
+			return false
+		}
+	}
+
+	return true
+}
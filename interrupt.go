@@ -8,31 +8,73 @@ const (
 	Joypad
 )
 
+// Interrupts are serviced in this order if more than one is pending.
 var priority = [...]uint8{VBlank, LCDStat, Timer, Serial, Joypad}
 
+// The number of machine cycles taken to dispatch an interrupt.
+const dispatchCycles = 5
+
 // An interrupt is an event that is handled before the next instruction executes.
+// https://gbdev.io/pandocs/Interrupts.html
 type Interrupt struct {
-	master             bool
+	// IME, which is cleared when the CPU starts up.
+	master bool
+
+	// EI only sets IME after the following instruction has run.
+	delay bool
+
 	enabled, requested uint8
 }
 
 func NewInterrupt() *Interrupt {
-	return &Interrupt{master: true}
+	return &Interrupt{}
+}
+
+// Return the interrupts that are both enabled and requested, regardless of IME.
+func (ir *Interrupt) pending() uint8 {
+	return ir.enabled & ir.requested & 0x1f
 }
 
-func (ir *Interrupt) todo() uint8 {
-	flags := ir.enabled & ir.requested
+// Return the pending interrupt with the highest priority, or 0 if there is none.
+func (ir *Interrupt) highest() uint8 {
+	flags := ir.pending()
 
-	if ir.master && flags > 0 {
-		for _, flag := range priority {
-			if (flags & flag) != 0 {
-				ir.requested &^= flag
-				ir.master = false
-			}
+	for _, flag := range priority {
+		if (flags & flag) != 0 {
+			return flag
 		}
-		return flags
-	} else {
-		return 0
 	}
 
+	return 0
+}
+
+// Set IME after the next instruction, as done by EI.
+func (ir *Interrupt) enable() {
+	ir.delay = true
+}
+
+// Clear IME, as done by DI, which also cancels an EI run just before it.
+func (ir *Interrupt) disable() {
+	ir.master = false
+	ir.delay = false
+}
+
+// Set IME if EI was run before the current instruction.
+func (ir *Interrupt) update() {
+	if ir.delay {
+		ir.delay = false
+		ir.master = true
+	}
+}
+
+// Return the address of the handler for an interrupt.
+func vector(flag uint8) uint16 {
+	addr := uint16(0x40)
+
+	for flag > 1 {
+		flag >>= 1
+		addr += 8
+	}
+
+	return addr
 }
@@ -0,0 +1,52 @@
+package tamago
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEIThenDI(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []uint8
+
+		// If the pending interrupt is dispatched within the program.
+		dispatched bool
+	}{
+		{"EI", []uint8{0xfb, 0x00, 0x00, 0x00}, true},
+		{"EI then DI", []uint8{0xfb, 0xf3, 0x00, 0x00}, false},
+		{"EI, NOP then DI", []uint8{0xfb, 0x00, 0xf3, 0x00}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewState()
+			if err := s.LoadBootFrom(bytes.NewReader(tt.program)); err != nil {
+				t.Fatal(err)
+			}
+
+			s.intr.enabled = VBlank
+			s.intr.requested = VBlank
+
+			for i := 0; i < len(tt.program); i++ {
+				s.step()
+			}
+
+			if dispatched := s.PC >= 0x40; dispatched != tt.dispatched {
+				t.Errorf("interrupt dispatched is %t (PC is 0x%04x), expected %t", dispatched, s.PC, tt.dispatched)
+			}
+		})
+	}
+}
+
+func TestDisableCancelsEnable(t *testing.T) {
+	ir := NewInterrupt()
+
+	ir.enable()
+	ir.disable()
+	ir.update()
+
+	if ir.master {
+		t.Error("IME was set by an EI that was cancelled by DI")
+	}
+}
@@ -0,0 +1,240 @@
+package tamago
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Messages sent over a link cable connection, each followed by a sequence number and a byte of data.
+const (
+	// Sent by the side providing the clock, holding the byte it is shifting out.
+	linkTransfer = iota + 1
+
+	// Sent back by the other side with the sequence number of the transfer, holding the byte it shifted out in exchange.
+	linkReply
+
+	// Sent by the side providing the clock when it gives up waiting for a reply, so the other side doesn't receive the transfer late.
+	linkCancel
+)
+
+// How long the side providing the clock waits for a reply, before giving up as if nothing is connected.
+const linkTimeout = time.Second
+
+// A message read from the connection, which is only valid until it is taken.
+type linkMessage struct {
+	seq, val uint8
+	valid    bool
+}
+
+// Link is a link cable connecting the serial ports of two Game Boys over a network connection.
+//
+// When a Game Boy starts a transfer with the internal clock, it sends a TRANSFER message and keeps running until the other side replies.
+// The other side only replies once it has started a transfer with the external clock,
+// so both sides finish the transfer on the same byte.
+// Each transfer has a sequence number, so replies to a transfer that timed out are ignored.
+type Link struct {
+	conn    net.Conn
+	timeout time.Duration
+
+	// The sequence number of the last transfer sent.
+	seq uint8
+
+	// Only the latest transfer and reply read from the connection are kept, so reading never blocks.
+	mu              sync.Mutex
+	transfer, reply linkMessage
+
+	// Signalled when a message is read, and closed once the connection is.
+	recv chan struct{}
+	done chan struct{}
+}
+
+// Create a link cable over an existing connection.
+func NewLink(conn net.Conn) *Link {
+	l := &Link{
+		conn:    conn,
+		timeout: linkTimeout,
+		recv:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	go l.read()
+
+	return l
+}
+
+// Listen on the network address (such as "tcp" and ":5000", or "unix" and "/tmp/tamago.sock"),
+// blocking until the other side connects.
+func ListenLink(network, addr string) (*Link, error) {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLink(conn), nil
+}
+
+// Connect to the other side listening on the network address.
+func DialLink(network, addr string) (*Link, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLink(conn), nil
+}
+
+// Create both ends of a link cable in the same process.
+// The serial port never blocks on a transfer, so both ends can be used from the same goroutine,
+// but Transfer blocks until the other side replies, so it must be called from another goroutine than the other end.
+func LinkPair() (*Link, *Link) {
+	a, b := net.Pipe()
+
+	return NewLink(a), NewLink(b)
+}
+
+// Read messages from the connection until it is closed.
+func (l *Link) read() {
+	defer close(l.done)
+
+	var msg [3]uint8
+
+	for {
+		if _, err := io.ReadFull(l.conn, msg[:]); err != nil {
+			return
+		}
+
+		m := linkMessage{seq: msg[1], val: msg[2], valid: true}
+
+		l.mu.Lock()
+
+		switch msg[0] {
+
+		case linkTransfer:
+			l.transfer = m
+
+		case linkReply:
+			l.reply = m
+
+		case linkCancel:
+			if l.transfer.seq == m.seq {
+				l.transfer.valid = false
+			}
+
+		default:
+			logger.Printf("invalid link cable message 0x%02x", msg[0])
+
+		}
+
+		l.mu.Unlock()
+
+		select {
+		case l.recv <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (l *Link) send(kind, seq, val uint8) bool {
+	if err := l.conn.SetWriteDeadline(time.Now().Add(l.timeout)); err != nil {
+		return false
+	}
+
+	_, err := l.conn.Write([]uint8{kind, seq, val})
+
+	return err == nil
+}
+
+// Send a byte to the other side without waiting for the reply.
+func (l *Link) start(out uint8) bool {
+	l.seq++
+
+	return l.send(linkTransfer, l.seq, out)
+}
+
+// Check if the other side has replied to the last transfer sent, discarding replies to earlier transfers.
+// If both sides start a transfer with the internal clock at the same time, they just exchange bytes.
+func (l *Link) poll() (uint8, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reply, transfer := l.reply, l.transfer
+	l.reply.valid = false
+
+	switch {
+
+	case reply.valid && reply.seq == l.seq:
+		return reply.val, true
+
+	case transfer.valid:
+		l.transfer.valid = false
+		return transfer.val, true
+
+	}
+
+	return 0, false
+}
+
+// Send a byte to the other side, blocking until it replies.
+// If the connection is closed or the other side doesn't reply in time, 0xff is received like when nothing is connected.
+func (l *Link) Transfer(out uint8) uint8 {
+	if !l.start(out) {
+		return 0xff
+	}
+
+	timeout := time.NewTimer(l.timeout)
+	defer timeout.Stop()
+
+	for {
+		if in, ok := l.poll(); ok {
+			return in
+		}
+
+		select {
+
+		case <-l.recv:
+
+		case <-l.done:
+			return 0xff
+
+		case <-timeout.C:
+			l.cancel()
+			return 0xff
+
+		}
+	}
+}
+
+// Give up waiting for the reply to the last transfer sent, so the other side doesn't receive it late.
+func (l *Link) cancel() {
+	l.send(linkCancel, l.seq, 0)
+}
+
+// Check if the other side has sent a byte, replying with out if so.
+// This never blocks, as the Game Boy waits for the other side to provide the clock.
+func (l *Link) Receive(out uint8) (uint8, bool) {
+	l.mu.Lock()
+	transfer := l.transfer
+	l.transfer.valid = false
+	l.mu.Unlock()
+
+	if !transfer.valid {
+		return 0, false
+	}
+
+	l.send(linkReply, transfer.seq, out)
+
+	return transfer.val, true
+}
+
+// Close the connection, which disconnects the link cable on both sides.
+func (l *Link) Close() error {
+	return l.conn.Close()
+}
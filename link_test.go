@@ -0,0 +1,107 @@
+package tamago
+
+import (
+	"testing"
+	"time"
+)
+
+// Create both ends of a link cable with the timeout for blocking transfers.
+func testLinkPair(t *testing.T, timeout time.Duration) (*Link, *Link) {
+	a, b := LinkPair()
+	a.timeout = timeout
+	b.timeout = timeout
+
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	return a, b
+}
+
+// Start a transfer on a serial port, running it until it finishes or the deadline passes.
+// The serial port pauses between each bit, to give the other side time to reply.
+func transferSerial(t *testing.T, s *serial, out, sc uint8, pause time.Duration) uint8 {
+	s.write(0xff01, out)
+	s.write(0xff02, sc)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.active() {
+		if time.Now().After(deadline) {
+			t.Error("serial transfer didn't finish")
+			break
+		}
+
+		s.step(serialBitCycles)
+		time.Sleep(pause)
+	}
+
+	return s.sb
+}
+
+func TestLinkIdle(t *testing.T) {
+	a, b := testLinkPair(t, time.Second)
+
+	master := newSerial(NewInterrupt())
+	master.device = a
+
+	// Nothing replies while the other side is idle, so each transfer times out in emulated time without blocking.
+	start := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if in := transferSerial(t, master, 0x10+uint8(i), 0x81, 0); in != 0xff {
+			t.Fatalf("transfer %d received 0x%02x while the other side is idle, expected 0xff", i, in)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed >= a.timeout {
+		t.Errorf("transfers blocked for %s while the other side is idle", elapsed)
+	}
+
+	slave := newSerial(NewInterrupt())
+	slave.device = b
+
+	// Give the other side time to read the cancelled transfers.
+	time.Sleep(10 * time.Millisecond)
+
+	received := make(chan uint8)
+	go func() {
+		received <- transferSerial(t, slave, 0x34, 0x80, time.Millisecond)
+	}()
+
+	// Wait for the slave to start its transfer, so the stale transfers would be received if they weren't cancelled.
+	time.Sleep(10 * time.Millisecond)
+
+	if in := transferSerial(t, master, 0x56, 0x81, time.Millisecond); in != 0x34 {
+		t.Errorf("master received 0x%02x, expected 0x34", in)
+	}
+
+	if in := <-received; in != 0x56 {
+		t.Errorf("slave received 0x%02x, expected 0x56", in)
+	}
+}
+
+func TestLinkStaleReply(t *testing.T) {
+	a, b := testLinkPair(t, 50*time.Millisecond)
+
+	if in := a.Transfer(0x12); in != 0xff {
+		t.Fatalf("transfer received 0x%02x while the other side is idle, expected 0xff", in)
+	}
+
+	// Reply to the transfer after it timed out.
+	b.send(linkReply, a.seq, 0x99)
+
+	go func() {
+		for {
+			if _, ok := b.Receive(0x34); ok {
+				return
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	if in := a.Transfer(0x56); in != 0x34 {
+		t.Errorf("transfer received 0x%02x, expected 0x34 instead of the late reply", in)
+	}
+}
@@ -0,0 +1,94 @@
+package tamago
+
+// MBC1 is a mapper supporting up to 2MiB of ROM and 32KiB of RAM.
+// https://gbdev.io/pandocs/MBC1.html
+type MBC1 struct {
+	cartridge
+
+	ramEnabled bool
+
+	// bank1 is the lower 5 bits of the ROM bank number.
+	// bank2 is either the upper 2 bits of the ROM bank number or the RAM bank number.
+	bank1, bank2 uint8
+
+	// In advanced banking mode (true), bank2 also applies to 0x0000-0x3fff and external RAM.
+	mode bool
+}
+
+func newMBC1(c cartridge) *MBC1 {
+	return &MBC1{cartridge: c, bank1: 1}
+}
+
+func (m *MBC1) Read(addr uint16) uint8 {
+	switch {
+
+	// rom bank 0 (or 0x20/0x40/0x60 on large ROMs in advanced mode)
+	case addr <= 0x3fff:
+		var bank int
+		if m.mode {
+			bank = int(m.bank2) << 5
+		}
+
+		return m.readROM(bank, addr)
+
+	// switchable rom bank
+	case addr <= 0x7fff:
+		return m.readROM(int(m.bank2)<<5|int(m.bank1), addr-0x4000)
+
+	// switchable ram bank
+	case addr >= 0xa000 && addr <= 0xbfff:
+		if !m.ramEnabled {
+			return 0xff
+		}
+
+		var bank int
+		if m.mode {
+			bank = int(m.bank2)
+		}
+
+		return m.readRAM(bank, addr-0xa000)
+
+	}
+
+	return 0xff
+}
+
+func (m *MBC1) Write(addr uint16, val uint8) {
+	switch {
+
+	// ram enable
+	case addr <= 0x1fff:
+		m.ramEnabled = (val & 0x0f) == 0x0a
+
+	// rom bank number
+	case addr <= 0x3fff:
+		m.bank1 = val & 0x1f
+
+		// Bank 0 can't be selected here, so it is treated as bank 1.
+		// Only the 5-bit register is checked, so banks 0x20, 0x40 and 0x60 are mapped to 0x21, 0x41 and 0x61 instead.
+		if m.bank1 == 0 {
+			m.bank1 = 1
+		}
+
+	// ram bank number/upper bits of rom bank number
+	case addr <= 0x5fff:
+		m.bank2 = val & 0x03
+
+	// banking mode select
+	case addr <= 0x7fff:
+		m.mode = (val & 0x01) != 0
+
+	case addr >= 0xa000 && addr <= 0xbfff:
+		if !m.ramEnabled {
+			return
+		}
+
+		var bank int
+		if m.mode {
+			bank = int(m.bank2)
+		}
+
+		m.writeRAM(bank, addr-0xa000, val)
+
+	}
+}
@@ -0,0 +1,91 @@
+package tamago
+
+import "testing"
+
+// Create a MBC1 cartridge with 2MiB of ROM and 32KiB of RAM, where the first byte of each ROM bank is its bank number.
+func testMBC1(t *testing.T) *MBC1 {
+	rom := make([]uint8, 128*romBankSize)
+	for bank := 0; bank < 128; bank++ {
+		rom[bank*romBankSize] = uint8(bank)
+	}
+
+	c, err := NewCartridge(rom, &RomInfo{CartridgeType: 0x03, RAMSize: 0x8000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c.(*MBC1)
+}
+
+func TestMBC1ROMBanks(t *testing.T) {
+	tests := []struct {
+		name         string
+		bank1, bank2 uint8
+		mode         uint8
+
+		// The banks mapped to 0x0000-0x3fff and 0x4000-0x7fff.
+		low, high uint8
+	}{
+		{"default", 0x01, 0, 0, 0x00, 0x01},
+		{"bank 0 is bank 1", 0x00, 0, 0, 0x00, 0x01},
+		{"upper bits ignored", 0x25, 0, 0, 0x00, 0x05},
+		{"bank 0x20 is 0x21", 0x00, 1, 0, 0x00, 0x21},
+		{"bank 0x40 is 0x41", 0x00, 2, 0, 0x00, 0x41},
+		{"bank 0x60 is 0x61", 0x00, 3, 0, 0x00, 0x61},
+		{"bank2 with bank1", 0x12, 2, 0, 0x00, 0x52},
+		{"advanced mode maps 0x20", 0x01, 1, 1, 0x20, 0x21},
+		{"advanced mode maps 0x40", 0x01, 2, 1, 0x40, 0x41},
+		{"advanced mode maps 0x60", 0x00, 3, 1, 0x60, 0x61},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMBC1(t)
+
+			m.Write(0x2000, tt.bank1)
+			m.Write(0x4000, tt.bank2)
+			m.Write(0x6000, tt.mode)
+
+			if low := m.Read(0x0000); low != tt.low {
+				t.Errorf("0x0000-0x3fff mapped to bank 0x%02x, expected 0x%02x", low, tt.low)
+			}
+
+			if high := m.Read(0x4000); high != tt.high {
+				t.Errorf("0x4000-0x7fff mapped to bank 0x%02x, expected 0x%02x", high, tt.high)
+			}
+		})
+	}
+}
+
+func TestMBC1RAMBanks(t *testing.T) {
+	m := testMBC1(t)
+
+	// RAM reads 0xff and ignores writes until it is enabled.
+	m.Write(0xa000, 0x12)
+	if v := m.Read(0xa000); v != 0xff {
+		t.Fatalf("disabled RAM read 0x%02x, expected 0xff", v)
+	}
+
+	m.Write(0x0000, 0x0a)
+
+	// In simple mode, bank2 doesn't select the RAM bank.
+	m.Write(0x4000, 2)
+	m.Write(0xa000, 0x34)
+
+	m.Write(0x6000, 1)
+	m.Write(0xa000, 0x56)
+
+	m.Write(0x6000, 0)
+	if v := m.Read(0xa000); v != 0x34 {
+		t.Errorf("RAM bank 0 read 0x%02x, expected 0x34", v)
+	}
+
+	m.Write(0x6000, 1)
+	if v := m.Read(0xa000); v != 0x56 {
+		t.Errorf("RAM bank 2 read 0x%02x, expected 0x56", v)
+	}
+
+	if !m.modified() {
+		t.Error("RAM writes didn't mark the cartridge as modified")
+	}
+}
@@ -0,0 +1,71 @@
+package tamago
+
+const mbc2RAMSize = 0x200
+
+// MBC2 is a mapper supporting up to 256KiB of ROM, with 512 half-bytes of RAM built into the mapper itself.
+// https://gbdev.io/pandocs/MBC2.html
+type MBC2 struct {
+	cartridge
+
+	ramEnabled bool
+
+	romBank uint8
+}
+
+func newMBC2(c cartridge) *MBC2 {
+	// The RAM size in the header is always 0, since the RAM is inside the mapper.
+	c.ram = make([]uint8, mbc2RAMSize)
+
+	return &MBC2{cartridge: c, romBank: 1}
+}
+
+func (m *MBC2) Read(addr uint16) uint8 {
+	switch {
+
+	// rom bank 0
+	case addr <= 0x3fff:
+		return m.readROM(0, addr)
+
+	// switchable rom bank
+	case addr <= 0x7fff:
+		return m.readROM(int(m.romBank), addr-0x4000)
+
+	// built-in ram
+	// Only the lower 9 bits of the address are used, so the RAM is echoed across 0xa000-0xbfff.
+	case addr >= 0xa000 && addr <= 0xbfff:
+		if !m.ramEnabled {
+			return 0xff
+		}
+
+		// Only the lower 4 bits of each byte exist, so the upper bits are undefined (set).
+		return m.ram[addr&(mbc2RAMSize-1)] | 0xf0
+
+	}
+
+	return 0xff
+}
+
+func (m *MBC2) Write(addr uint16, val uint8) {
+	switch {
+
+	// ram enable/rom bank number
+	// Bit 8 of the address selects which register is written to.
+	case addr <= 0x3fff:
+		if (addr & 0x100) == 0 {
+			m.ramEnabled = (val & 0x0f) == 0x0a
+		} else {
+			m.romBank = val & 0x0f
+
+			if m.romBank == 0 {
+				m.romBank = 1
+			}
+		}
+
+	case addr >= 0xa000 && addr <= 0xbfff:
+		if m.ramEnabled {
+			m.ram[addr&(mbc2RAMSize-1)] = val & 0x0f
+			m.dirty = true
+		}
+
+	}
+}
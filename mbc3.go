@@ -0,0 +1,149 @@
+package tamago
+
+// MBC3 is a mapper supporting up to 2MiB of ROM, 32KiB of RAM and an optional real time clock.
+// https://gbdev.io/pandocs/MBC3.html
+type MBC3 struct {
+	cartridge
+
+	// RTC is nil if the cartridge doesn't have a clock.
+	RTC *RTC
+
+	// Enables both RAM and the RTC registers.
+	enabled bool
+
+	romBank uint8
+
+	// 0x00-0x03 selects a RAM bank, and 0x08-0x0c selects a RTC register.
+	ramBank uint8
+}
+
+func newMBC3(c cartridge, hasRTC bool) *MBC3 {
+	m := &MBC3{cartridge: c, romBank: 1}
+
+	if hasRTC {
+		m.RTC = NewRTC()
+	}
+
+	return m
+}
+
+func (m *MBC3) Read(addr uint16) uint8 {
+	switch {
+
+	// rom bank 0
+	case addr <= 0x3fff:
+		return m.readROM(0, addr)
+
+	// switchable rom bank
+	case addr <= 0x7fff:
+		return m.readROM(int(m.romBank), addr-0x4000)
+
+	// switchable ram bank/rtc register
+	case addr >= 0xa000 && addr <= 0xbfff:
+		if !m.enabled {
+			return 0xff
+		}
+
+		if m.ramBank >= rtcSeconds {
+			if m.RTC != nil && m.ramBank <= rtcDaysHi {
+				return m.RTC.read(m.ramBank)
+			}
+
+			return 0xff
+		}
+
+		return m.readRAM(int(m.ramBank), addr-0xa000)
+
+	}
+
+	return 0xff
+}
+
+func (m *MBC3) Write(addr uint16, val uint8) {
+	switch {
+
+	// ram and rtc enable
+	case addr <= 0x1fff:
+		m.enabled = (val & 0x0f) == 0x0a
+
+	// rom bank number
+	case addr <= 0x3fff:
+		m.romBank = val & 0x7f
+
+		if m.romBank == 0 {
+			m.romBank = 1
+		}
+
+	// ram bank number/rtc register select
+	case addr <= 0x5fff:
+		m.ramBank = val
+
+	// latch clock data
+	case addr <= 0x7fff:
+		if m.RTC != nil {
+			m.RTC.writeLatch(val)
+		}
+
+	case addr >= 0xa000 && addr <= 0xbfff:
+		if !m.enabled {
+			return
+		}
+
+		if m.ramBank >= rtcSeconds {
+			if m.RTC != nil && m.ramBank <= rtcDaysHi {
+				m.RTC.write(m.ramBank, val)
+			}
+
+			return
+		}
+
+		m.writeRAM(int(m.ramBank), addr-0xa000, val)
+
+	}
+}
+
+// Given the number of cycles run, advance the clock (if any).
+func (m *MBC3) step(cycles int) {
+	if m.RTC != nil {
+		m.RTC.step(cycles)
+	}
+}
+
+// Check if RAM or the clock (if any) has changed since the last call to modified.
+// The clock changes as it runs, so cartridges with a clock are saved even if RAM is never written to.
+func (m *MBC3) modified() bool {
+	ram := m.cartridge.modified()
+	if m.RTC == nil {
+		return ram
+	}
+
+	rtc := m.RTC.dirty
+	m.RTC.dirty = false
+
+	return ram || rtc
+}
+
+// Return the contents of RAM, followed by the clock data (if any).
+func (m *MBC3) Save() []uint8 {
+	save := m.cartridge.Save()
+
+	if m.RTC != nil {
+		save = append(save, m.RTC.save()...)
+	}
+
+	return save
+}
+
+// Restore RAM, followed by the clock data (if any).
+// Saves without clock data are accepted, in which case the clock is left as-is.
+func (m *MBC3) Restore(save []uint8) error {
+	if err := m.cartridge.Restore(save); err != nil {
+		return err
+	}
+
+	if rtc := save[len(m.ram):]; m.RTC != nil && len(rtc) >= rtcSaveSizeLegacy {
+		m.RTC.restore(rtc)
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package tamago
+
+// MBC5 is a mapper supporting up to 8MiB of ROM (512 banks) and 128KiB of RAM (16 banks).
+// Some MBC5 cartridges have a rumble motor, which is controlled by bit 3 of the RAM bank register.
+// https://gbdev.io/pandocs/MBC5.html
+type MBC5 struct {
+	cartridge
+
+	// OnRumble is called when the rumble motor is turned on or off.
+	// It is never called if the cartridge doesn't have a rumble motor.
+	OnRumble func(on bool)
+
+	ramEnabled bool
+
+	// The ROM bank number is 9 bits wide.
+	romBank uint16
+	ramBank uint8
+
+	rumble, rumbling bool
+}
+
+func newMBC5(c cartridge, hasRumble bool) *MBC5 {
+	return &MBC5{cartridge: c, romBank: 1, rumble: hasRumble}
+}
+
+func (m *MBC5) Read(addr uint16) uint8 {
+	switch {
+
+	// rom bank 0
+	case addr <= 0x3fff:
+		return m.readROM(0, addr)
+
+	// switchable rom bank
+	case addr <= 0x7fff:
+		return m.readROM(int(m.romBank), addr-0x4000)
+
+	// switchable ram bank
+	case addr >= 0xa000 && addr <= 0xbfff:
+		if !m.ramEnabled {
+			return 0xff
+		}
+
+		return m.readRAM(int(m.ramBank), addr-0xa000)
+
+	}
+
+	return 0xff
+}
+
+func (m *MBC5) Write(addr uint16, val uint8) {
+	switch {
+
+	// ram enable
+	case addr <= 0x1fff:
+		m.ramEnabled = (val & 0x0f) == 0x0a
+
+	// lower 8 bits of rom bank number
+	// Unlike other mappers, bank 0 can be mapped to 0x4000-0x7fff.
+	case addr <= 0x2fff:
+		m.romBank = (m.romBank & 0x100) | uint16(val)
+
+	// 9th bit of rom bank number
+	case addr <= 0x3fff:
+		m.romBank = (m.romBank & 0xff) | uint16(val&0x01)<<8
+
+	// ram bank number
+	case addr <= 0x5fff:
+		if m.rumble {
+			// Bit 3 controls the motor, so only 8 RAM banks are usable.
+			m.setRumble((val & 0x08) != 0)
+			m.ramBank = val & 0x07
+		} else {
+			m.ramBank = val & 0x0f
+		}
+
+	case addr >= 0xa000 && addr <= 0xbfff:
+		if m.ramEnabled {
+			m.writeRAM(int(m.ramBank), addr-0xa000, val)
+		}
+
+	}
+}
+
+// Check if the rumble motor is on.
+func (m *MBC5) Rumbling() bool {
+	return m.rumbling
+}
+
+func (m *MBC5) setRumble(on bool) {
+	if on == m.rumbling {
+		return
+	}
+
+	m.rumbling = on
+
+	if m.OnRumble != nil {
+		m.OnRumble(on)
+	}
+}
@@ -3,36 +3,97 @@ package tamago
 import (
 	"errors"
 	"io"
-	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 var TooLargeErr = errors.New("ROM is too large!")
 
 type MMU struct {
 	bootrom [0x100]uint8
-	rom     [0x8000]uint8
-	vram    [0x2000]uint8
-	ram     [0x4000]uint8
+	vram    [0x4000]uint8
+	wram    [0x8000]uint8
 	oam     [0xa0]uint8
 	hram    [0x80]uint8
 
+	dma  oamDMA
+	hdma hdma
+
+	// In CGB mode, VBK selects the VRAM bank and SVBK selects the work RAM bank at 0xd000-0xdfff.
+	// https://gbdev.io/pandocs/CGB_Registers.html
+	cgb       bool
+	vbk, svbk uint8
+
+	// In SGB mode, this receives packets sent through the joypad register.
+	sgb *SGB
+
+	// KEY1 prepares a switch to (or from) double speed mode, which is done by the next STOP instruction.
+	// https://gbdev.io/pandocs/CGB_Registers.html#ff4d--key1-cgb-mode-only-prepare-speed-switch
+	double, prepare bool
+
+	cart   Cartridge
+	info   *RomInfo
+	intr   *Interrupt
 	input  *Input
+	timer  *timer
+	serial *serial
+	apu    *APU
 	render *Render
 
 	hasBoot, hasROM bool
+
+	// If true, the CPU can access VRAM and OAM while the PPU is using them.
+	lenient bool
+
+	// The path to save battery-backed RAM to, if the cartridge has a battery.
+	savePath string
 }
 
 func NewMMU() *MMU {
 	m := &MMU{
+		intr:  NewInterrupt(),
 		input: NewInput(),
 	}
-	m.render = NewRender(m.vram[:], m.oam[:])
+	m.timer = newTimer(m.intr)
+	m.serial = newSerial(m.intr)
+	m.apu = NewAPU()
+	m.render = NewRender(m.vram[:], m.oam[:], m.intr)
+
+	// HDMA5 reads 0xff when no transfer has been done.
+	m.hdma.length = 0x7f
 
 	return m
 }
 
+// Read the byte at addr, as seen by the CPU.
 func (m *MMU) Read(addr uint16) uint8 {
+	if m.dma.blocks(addr) {
+		// OAM reads as 0xff, and other blocked addresses read the byte being transferred.
+		if addr >= 0xfe00 {
+			return 0xff
+		}
+
+		return m.dma.value
+	}
+
+	if !m.lenient && m.render.blocks(addr) {
+		return 0xff
+	}
+
+	return m.read(addr)
+}
+
+// Write a byte to addr, as done by the CPU.
+func (m *MMU) Write(addr uint16, val uint8) {
+	if m.dma.blocks(addr) || (!m.lenient && m.render.blocks(addr)) {
+		return
+	}
+
+	m.write(addr, val)
+}
+
+func (m *MMU) read(addr uint16) uint8 {
 	//logger.Printf("reading from addr 0x%x", addr)
 
 	switch {
@@ -44,20 +105,31 @@ func (m *MMU) Read(addr uint16) uint8 {
 			return m.bootrom[addr]
 		}
 
-		// TODO: Bank switching?
-		return m.rom[addr]
+		if m.hasROM {
+			return m.cart.Read(addr)
+		}
+
+		return 0xff
 
 	// video ram
 	case addr <= 0x9fff:
-		return m.vram[addr-0x8000]
+		return m.vram[m.vramOffset(addr)]
+
+	// external ram
+	case addr <= 0xbfff:
+		if m.hasROM {
+			return m.cart.Read(addr)
+		}
 
-	// external + work ram
+		return 0xff
+
+	// work ram
 	case addr <= 0xdfff:
-		return m.ram[addr-0xa000]
+		return m.wram[m.wramOffset(addr)]
 
 	// echo ram
 	case addr <= 0xfdff:
-		return m.ram[addr-0xe000]
+		return m.wram[m.wramOffset(addr-0x2000)]
 
 	// oam (sprite ram)
 	case addr <= 0xfe9f:
@@ -71,14 +143,23 @@ func (m *MMU) Read(addr uint16) uint8 {
 	*/
 
 	case addr == 0xff00:
+		if m.sgb != nil {
+			return m.sgb.poll(m.input.Poll())
+		}
+
 		return m.input.Poll()
 
-	case addr == 0xff04:
-		// TODO: Implement actual timer?
-		return uint8(rand.Intn(256))
+	case addr == 0xff01 || addr == 0xff02:
+		return m.serial.read(addr)
+
+	case addr >= 0xff04 && addr <= 0xff07:
+		return m.timer.read(addr)
 
 	case addr == 0xff0f:
-		return m.render.intr.requested
+		return m.intr.requested
+
+	case addr >= 0xff10 && addr <= 0xff3f:
+		return m.apu.read(addr)
 
 	case addr == 0xff40:
 		return m.render.lcdc.uint8
@@ -89,9 +170,52 @@ func (m *MMU) Read(addr uint16) uint8 {
 	case addr == 0xff43:
 		return m.render.sx
 
+	case addr == 0xff41:
+		return m.render.readStat()
+
 	case addr == 0xff44:
 		return m.render.line
 
+	case addr == 0xff45:
+		return m.render.lyc
+
+	case addr == 0xff46:
+		return m.dma.reg
+
+	case addr == 0xff4a:
+		return m.render.wy
+
+	case addr == 0xff4b:
+		return m.render.wx
+
+	case addr == 0xff4d && m.cgb:
+		return tobit(m.double)<<7 | 0x7e | tobit(m.prepare)
+
+	case addr == 0xff4f && m.cgb:
+		return m.vbk | 0xfe
+
+	// HDMA1-HDMA4 are write-only.
+	case addr >= 0xff51 && addr <= 0xff54 && m.cgb:
+		return 0xff
+
+	case addr == 0xff55 && m.cgb:
+		return m.hdma.status()
+
+	case addr == 0xff68 && m.cgb:
+		return m.render.bcps | 0x40
+
+	case addr == 0xff69 && m.cgb:
+		return readPalette(m.render.bcps, &m.render.bgRAM)
+
+	case addr == 0xff6a && m.cgb:
+		return m.render.ocps | 0x40
+
+	case addr == 0xff6b && m.cgb:
+		return readPalette(m.render.ocps, &m.render.objRAM)
+
+	case addr == 0xff70 && m.cgb:
+		return m.svbk | 0xf8
+
 	case addr <= 0xff7f:
 		// unimplemented i/o
 
@@ -105,7 +229,7 @@ func (m *MMU) Read(addr uint16) uint8 {
 
 	// interrupt enable
 	case addr == 0xffff:
-		return m.render.intr.enabled
+		return m.intr.enabled
 
 	}
 
@@ -113,32 +237,45 @@ func (m *MMU) Read(addr uint16) uint8 {
 	return 0
 }
 
-func (m *MMU) Write(addr uint16, val uint8) {
+func (m *MMU) write(addr uint16, val uint8) {
 	//logger.Printf("writing to addr 0x%x", addr)
 
 	impl := true
 
 	switch {
 
-	// rom
+	// rom (mapper registers)
 	case addr <= 0x7fff:
-		impl = false
+		if m.hasROM {
+			m.cart.Write(addr, val)
+		} else {
+			impl = false
+		}
 
 	// video ram
 	case addr <= 0x9fff:
-		m.vram[addr-0x8000] = val
+		offset := m.vramOffset(addr)
+		m.vram[offset] = val
 
 		if addr <= 0x97ff {
-			m.render.updateTile(addr - 0x8000)
+			m.render.updateTile(offset)
 		}
 
-	// external + work ram
+	// external ram
+	case addr <= 0xbfff:
+		if m.hasROM {
+			m.cart.Write(addr, val)
+		} else {
+			impl = false
+		}
+
+	// work ram
 	case addr <= 0xdfff:
-		m.ram[addr-0xa000] = val
+		m.wram[m.wramOffset(addr)] = val
 
 	// echo ram
 	case addr <= 0xfdff:
-		m.ram[addr-0xe000] = val
+		m.wram[m.wramOffset(addr-0x2000)] = val
 
 	// oam (sprite ram)
 	case addr <= 0xfe9f:
@@ -156,11 +293,24 @@ func (m *MMU) Write(addr uint16, val uint8) {
 	case addr == 0xff00:
 		m.input.Select(val)
 
+		if m.sgb != nil {
+			m.sgb.write(val)
+		}
+
+	case addr == 0xff01 || addr == 0xff02:
+		m.serial.write(addr, val)
+
+	case addr >= 0xff04 && addr <= 0xff07:
+		m.timer.write(addr, val)
+
 	case addr == 0xff0f:
-		m.render.intr.requested = val
+		m.intr.requested = val
+
+	case addr >= 0xff10 && addr <= 0xff3f:
+		m.apu.write(addr, val)
 
 	case addr == 0xff40:
-		m.render.lcdc.uint8 = val
+		m.render.writeLCDC(val)
 
 	case addr == 0xff42:
 		m.render.sy = val
@@ -168,8 +318,14 @@ func (m *MMU) Write(addr uint16, val uint8) {
 	case addr == 0xff43:
 		m.render.sx = val
 
+	case addr == 0xff41:
+		m.render.writeStat(val)
+
+	// LY is read-only, so writes are ignored.
 	case addr == 0xff44:
-		m.render.line = val
+
+	case addr == 0xff45:
+		m.render.writeLYC(val)
 
 	// Background palette
 	case addr == 0xff47:
@@ -182,6 +338,39 @@ func (m *MMU) Write(addr uint16, val uint8) {
 	case addr == 0xff49:
 		m.render.updatePalette(&m.render.obj1, val)
 
+	case addr == 0xff46:
+		m.dma.start(val)
+
+	case addr == 0xff4a:
+		m.render.wy = val
+
+	case addr == 0xff4b:
+		m.render.wx = val
+
+	case addr == 0xff4d && m.cgb:
+		m.prepare = (val & 0x01) != 0
+
+	case addr == 0xff4f && m.cgb:
+		m.vbk = val & 0x01
+
+	case addr >= 0xff51 && addr <= 0xff55 && m.cgb:
+		m.writeHDMA(addr, val)
+
+	case addr == 0xff68 && m.cgb:
+		m.render.bcps = val & 0xbf
+
+	case addr == 0xff69 && m.cgb:
+		writePalette(&m.render.bcps, &m.render.bgRAM, &m.render.bgColour, val)
+
+	case addr == 0xff6a && m.cgb:
+		m.render.ocps = val & 0xbf
+
+	case addr == 0xff6b && m.cgb:
+		writePalette(&m.render.ocps, &m.render.objRAM, &m.render.objColour, val)
+
+	case addr == 0xff70 && m.cgb:
+		m.svbk = val & 0x07
+
 	case addr <= 0xff80:
 		// unimplemented i/o
 		impl = false
@@ -196,7 +385,7 @@ func (m *MMU) Write(addr uint16, val uint8) {
 
 	// interrupt enable
 	case addr == 0xffff:
-		m.render.intr.enabled = val
+		m.intr.enabled = val
 
 	}
 
@@ -205,6 +394,74 @@ func (m *MMU) Write(addr uint16, val uint8) {
 	}
 }
 
+// Switch between normal and double speed mode, if a switch was prepared by writing to KEY1.
+// This returns false if no switch was prepared.
+func (m *MMU) switchSpeed() bool {
+	if !m.cgb || !m.prepare {
+		return false
+	}
+
+	m.double = !m.double
+	m.prepare = false
+
+	// The divider is reset by the switch.
+	m.timer.write(0xff04, 0)
+
+	return true
+}
+
+// Check if the CPU is running in CGB double speed mode.
+func (m *MMU) DoubleSpeed() bool {
+	return m.double
+}
+
+// Return the offset in VRAM of addr (0x8000-0x9fff) in the current bank.
+func (m *MMU) vramOffset(addr uint16) uint16 {
+	return uint16(m.vbk)*0x2000 + addr - 0x8000
+}
+
+// Return the offset in work RAM of addr (0xc000-0xdfff).
+// 0xd000-0xdfff is bank 1, unless another bank is selected by SVBK in CGB mode.
+func (m *MMU) wramOffset(addr uint16) uint16 {
+	if addr <= 0xcfff {
+		return addr - 0xc000
+	}
+
+	// Selecting bank 0 selects bank 1 instead.
+	bank := uint16(m.svbk)
+	if bank == 0 {
+		bank = 1
+	}
+
+	return bank*0x1000 + addr - 0xd000
+}
+
+// Given the number of cycles run at normal speed, step forward the hardware attached to the MMU.
+func (m *MMU) step(cycles int) {
+	// In double speed mode, the timer, serial port and OAM DMA run at the CPU's speed, so twice as many cycles pass for them.
+	cpu := cycles
+	if m.double {
+		cpu *= 2
+	}
+
+	m.stepDMA(cpu)
+	m.timer.step(cpu)
+	m.serial.step(cpu)
+	m.apu.step(cycles)
+
+	before := m.render.mode
+	m.render.step(cycles)
+
+	// HBlank DMA copies a block at the start of each HBlank.
+	if before != mode.HBlank && m.render.mode == mode.HBlank {
+		m.hblankDMA()
+	}
+
+	if c, ok := m.cart.(clocked); ok {
+		c.step(cycles)
+	}
+}
+
 // Read the byte at addr, where addr is the register's value.
 func (m *MMU) ReadFrom(r *Register) uint8 {
 	return m.Read(r.Get())
@@ -236,18 +493,48 @@ func (m *MMU) LoadFrom(rom io.Reader) error {
 		return err
 	}
 
-	// TODO: bank switching?
-	if len(buf) > len(m.rom) {
-		return TooLargeErr
+	info, err := ParseHeader(buf)
+	switch {
+
+	// Small test ROMs without a header are loaded as ROM only.
+	case errors.Is(err, NoHeaderErr):
+		logger.Printf("ROM has no header, loading as ROM only")
+		info = &RomInfo{}
+
+	case err != nil:
+		return err
+
+	case !info.HeaderChecksumValid:
+		logger.Printf("header checksum is invalid, the bootrom will lock up")
+
+	}
+
+	cart, err := NewCartridge(buf, info)
+	if err != nil {
+		return err
 	}
 
-	copy(m.rom[:], buf)
+	m.cart = cart
+	m.info = info
 	m.hasROM = true
 
+	// Games that support the CGB run in CGB mode.
+	m.cgb = info.CGB()
+	m.render.cgb = m.cgb
+	m.serial.cgb = m.cgb
+
+	// Games that support the SGB run in SGB mode, unless they are running in CGB mode.
+	m.sgb = nil
+	if info.SGB() && !m.cgb {
+		m.sgb = NewSGB()
+	}
+	m.render.sgb = m.sgb
+
 	return nil
 }
 
 // Load a cartriage from a filename.
+// If the cartridge has battery-backed RAM, it is restored from (and later saved to) a .sav file next to the ROM.
 func (m *MMU) Load(rom string) error {
 	f, err := os.Open(rom)
 	defer f.Close()
@@ -260,9 +547,91 @@ func (m *MMU) Load(rom string) error {
 		return e
 	}
 
+	m.savePath = ""
+
+	if !m.cart.Battery() {
+		return nil
+	}
+
+	path := strings.TrimSuffix(rom, filepath.Ext(rom)) + ".sav"
+
+	save, err := os.ReadFile(path)
+	switch {
+
+	case err == nil:
+		if err := m.cart.Restore(save); err != nil {
+			return err
+		}
+
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+
+	}
+
+	// The save path is only set once the existing save has been restored,
+	// so a save that failed to restore is never overwritten.
+	m.savePath = path
+
+	return nil
+}
+
+// Export the cartridge's battery-backed RAM (and clock, if any) as a save file.
+// If there is no cartridge or it has no battery, nil is returned.
+func (m *MMU) ExportSave() []uint8 {
+	if !m.hasROM || !m.cart.Battery() {
+		return nil
+	}
+
+	return m.cart.Save()
+}
+
+// Import a save file into the cartridge's battery-backed RAM (and clock, if any).
+// The imported save is written to the save file by the next autosave.
+func (m *MMU) ImportSave(save []uint8) error {
+	if !m.hasROM {
+		return NoROMErr
+	}
+
+	if !m.cart.Battery() {
+		return NoBatteryErr
+	}
+
+	if err := m.cart.Restore(save); err != nil {
+		return err
+	}
+
+	m.cart.touch()
+
 	return nil
 }
 
+// Write the cartridge's battery-backed RAM to its save file.
+// This does nothing if the cartridge wasn't loaded from a file or has no battery.
+func (m *MMU) Flush() error {
+	if m.savePath == "" {
+		return nil
+	}
+
+	// Write to a temporary file first, so the old save isn't lost if writing fails halfway.
+	tmp := m.savePath + ".tmp"
+	if err := os.WriteFile(tmp, m.cart.Save(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, m.savePath)
+}
+
+// Flush the save file if RAM has been written to since the last autosave.
+func (m *MMU) autosave() {
+	if m.savePath == "" || !m.cart.modified() {
+		return
+	}
+
+	if err := m.Flush(); err != nil {
+		logger.Printf("autosave failed: %s", err)
+	}
+}
+
 // Load a bootrom from a reader.
 func (m *MMU) LoadBootFrom(rom io.Reader) error {
 	buf, err := io.ReadAll(rom)
@@ -296,6 +665,53 @@ func (m *MMU) LoadBoot(rom string) error {
 	return nil
 }
 
+// Select how the PPU draws scanlines.
+func (m *MMU) SetRenderer(r Renderer) {
+	m.render.renderer = r
+}
+
+// Allow the CPU to access VRAM and OAM at any time, even while the PPU is using them.
+// Real hardware doesn't allow this, so code that relies on it will break there.
+func (m *MMU) SetLenient(on bool) {
+	m.lenient = on
+}
+
+// Connect a device to the serial port, or disconnect it if d is nil.
+func (m *MMU) SetSerialDevice(d SerialDevice) {
+	if d == nil {
+		d = disconnected{}
+	}
+
+	m.serial.cancel()
+	m.serial.device = d
+}
+
+// Check if the emulator is running in CGB mode, which is selected by the header of the loaded cartridge.
+func (m *MMU) CGB() bool {
+	return m.cgb
+}
+
+// Return the SGB if running in SGB mode, which is selected by the header of the loaded cartridge.
+// Otherwise, nil is returned.
+func (m *MMU) SGB() *SGB {
+	return m.sgb
+}
+
+// Return the audio processing unit, which can be read from to play audio.
+func (m *MMU) APU() *APU {
+	return m.apu
+}
+
+// Return the header of the loaded cartridge, or nil if no cartridge has been loaded.
+func (m *MMU) Info() *RomInfo {
+	return m.info
+}
+
+// Return the loaded cartridge, or nil if no cartridge has been loaded.
+func (m *MMU) Cartridge() Cartridge {
+	return m.cart
+}
+
 // Check if a rom/bootrom has been loaded.
 func (m *MMU) Loaded() bool {
 	return m.hasBoot || m.hasROM
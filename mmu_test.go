@@ -0,0 +1,69 @@
+package tamago
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestImportSave(t *testing.T) {
+	tests := []struct {
+		name          string
+		cartridgeType uint8
+		err           error
+	}{
+		{"battery", 0x03, nil},
+		{"no battery", 0x02, NoBatteryErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rom := testROM()
+			rom[0x147] = tt.cartridgeType
+			rom[0x149] = 0x02
+
+			m := NewMMU()
+			if err := m.LoadFrom(bytes.NewReader(rom)); err != nil {
+				t.Fatal(err)
+			}
+
+			save := make([]uint8, 0x2000)
+			save[0] = 0x42
+
+			if err := m.ImportSave(save); !errors.Is(err, tt.err) {
+				t.Fatalf("importing returned %v, expected %v", err, tt.err)
+			}
+
+			if tt.err != nil {
+				return
+			}
+
+			if !m.cart.modified() {
+				t.Error("imported save wasn't marked as modified")
+			}
+
+			if v := m.ExportSave()[0]; v != 0x42 {
+				t.Errorf("RAM is 0x%02x after importing, expected 0x42", v)
+			}
+		})
+	}
+}
+
+func TestLoadWithoutHeader(t *testing.T) {
+	m := NewMMU()
+	if err := m.LoadFrom(bytes.NewReader([]uint8{0x00, 0x3c, 0x18, 0xfd})); err != nil {
+		t.Fatalf("loading a ROM without a header failed: %s", err)
+	}
+
+	if _, ok := m.cart.(*ROMOnly); !ok {
+		t.Errorf("ROM without a header loaded as %T, expected ROM only", m.cart)
+	}
+
+	if v := m.cart.Read(0x0001); v != 0x3c {
+		t.Errorf("ROM read 0x%02x, expected 0x3c", v)
+	}
+
+	if _, err := NewCartridge(nil, nil); err != nil {
+		t.Errorf("creating a cartridge without a header failed: %s", err)
+	}
+}
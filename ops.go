@@ -208,7 +208,7 @@ var ops = [256]Instruction{
 		cycles: 1,
 
 		fn: func(s *State, v Value) {
-			s.stopped = true
+			s.stop()
 		},
 	},
 
@@ -1379,10 +1379,7 @@ var ops = [256]Instruction{
 		cycles: 1,
 
 		fn: func(s *State, v Value) {
-			if !s.render.intr.master {
-				// an interrupt has occured because master was toggled to false
-				s.PC++
-			}
+			s.halt()
 		},
 	},
 
@@ -2517,7 +2514,7 @@ var ops = [256]Instruction{
 		cycles: 4,
 
 		fn: func(s *State, v Value) {
-			s.render.intr.master = true
+			s.intr.master = true
 			s.PC = s.Pop()
 		},
 	},
@@ -2770,7 +2767,7 @@ var ops = [256]Instruction{
 		cycles: 1,
 
 		fn: func(s *State, v Value) {
-			s.render.intr.master = false
+			s.intr.disable()
 		},
 	},
 
@@ -2858,7 +2855,7 @@ var ops = [256]Instruction{
 		cycles: 1,
 
 		fn: func(s *State, v Value) {
-			s.render.intr.master = false
+			s.intr.enable()
 		},
 	},
 
@@ -14,3 +14,25 @@ var (
 )
 
 type Palette [4]color.RGBA
+
+// Return the shade (0-3) of a DMG colour, which is its index in the default palette.
+func shadeOf(c *color.RGBA) uint8 {
+	for i := range DefaultPalette {
+		if DefaultPalette[i] == *c {
+			return uint8(i)
+		}
+	}
+
+	return 0
+}
+
+// Convert a 15-bit CGB colour (5 bits each of red, green and blue, from lowest to highest) to RGBA.
+func colour15(c uint16) color.RGBA {
+	// Scale each 5-bit component to 8 bits, so 0x1f maps to 0xff.
+	scale := func(v uint16) uint8 {
+		v &= 0x1f
+		return uint8(v<<3 | v>>2)
+	}
+
+	return color.RGBA{scale(c), scale(c >> 5), scale(c >> 10), 255}
+}
@@ -0,0 +1,312 @@
+package tamago
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+// Commands sent to the printer in packets.
+const (
+	printerInit   = 0x01
+	printerPrint  = 0x02
+	printerData   = 0x04
+	printerStatus = 0x0f
+)
+
+// Bits of the printer's status byte.
+const (
+	statusChecksum    = 0x01
+	statusPrinting    = 0x02
+	statusFull        = 0x04
+	statusUnprocessed = 0x08
+)
+
+// States of the printer while receiving a packet, one for each byte.
+const (
+	packetMagic1 = iota
+	packetMagic2
+	packetCommand
+	packetCompression
+	packetLengthLo
+	packetLengthHi
+	packetData
+	packetChecksumLo
+	packetChecksumHi
+	packetAlive
+	packetStatus
+)
+
+const (
+	// The printer always prints 160 pixels (20 tiles) wide.
+	printerWidth = 160
+
+	// The printer's buffer holds up to 9 data packets of 2 rows of tiles each, the size of the screen.
+	printerBufferSize = 0x280 * 9
+)
+
+// Printer is the Game Boy Printer, which is connected to the serial port.
+// Each printed image is written to a PNG file in a directory.
+// https://gbdev.io/pandocs/Gameboy_Printer.html
+type Printer struct {
+	dir   string
+	count int
+
+	// The packet being received.
+	state      int
+	command    uint8
+	compressed bool
+	length     uint16
+	data       []uint8
+	checksum   uint16
+	sum        uint16
+
+	// The tile data received so far, which is printed by the print command.
+	buf    []uint8
+	status uint8
+}
+
+// Create a printer that writes printed images to dir, creating it if it doesn't exist.
+func NewPrinter(dir string) (*Printer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Printer{dir: dir}, nil
+}
+
+// Receive a byte from the Game Boy, returning the printer's reply.
+// The printer replies 0x81 to the first byte after a packet to show it is connected, and its status to the second.
+func (p *Printer) Transfer(out uint8) uint8 {
+	switch p.state {
+
+	case packetMagic1:
+		if out == 0x88 {
+			p.state = packetMagic2
+		}
+
+		return 0x00
+
+	case packetMagic2:
+		if out == 0x33 {
+			p.state = packetCommand
+		} else {
+			p.state = packetMagic1
+		}
+
+		return 0x00
+
+	case packetCommand:
+		p.command = out
+		p.sum = uint16(out)
+
+	case packetCompression:
+		p.compressed = (out & 0x01) != 0
+		p.sum += uint16(out)
+
+	case packetLengthLo:
+		p.length = uint16(out)
+		p.sum += uint16(out)
+
+	case packetLengthHi:
+		p.length |= uint16(out) << 8
+		p.sum += uint16(out)
+		p.data = p.data[:0]
+
+		// Skip straight to the checksum if there is no data.
+		if p.length == 0 {
+			p.state = packetChecksumLo
+			return 0x00
+		}
+
+	case packetData:
+		p.data = append(p.data, out)
+		p.sum += uint16(out)
+
+		if len(p.data) < int(p.length) {
+			return 0x00
+		}
+
+	case packetChecksumLo:
+		p.checksum = uint16(out)
+
+	case packetChecksumHi:
+		p.checksum |= uint16(out) << 8
+		p.run()
+
+	case packetAlive:
+		p.state = packetStatus
+		return 0x81
+
+	case packetStatus:
+		p.state = packetMagic1
+		status := p.status
+
+		// Printing is done instantly, but the status only changes once the Game Boy has seen it.
+		p.status &^= statusPrinting
+
+		return status
+
+	}
+
+	p.state++
+
+	return 0x00
+}
+
+// The printer never provides the clock.
+func (p *Printer) Receive(out uint8) (uint8, bool) {
+	return 0, false
+}
+
+// Run the command in a packet once it has been received.
+func (p *Printer) run() {
+	if p.checksum != p.sum {
+		p.status |= statusChecksum
+		return
+	}
+
+	p.status &^= statusChecksum
+
+	switch p.command {
+
+	case printerInit:
+		p.buf = p.buf[:0]
+		p.status = 0
+
+	case printerData:
+		data := p.data
+		if p.compressed {
+			data = decompress(data)
+		}
+
+		p.buf = append(p.buf, data...)
+		if len(p.buf) > printerBufferSize {
+			p.buf = p.buf[:printerBufferSize]
+		}
+
+		if len(p.buf) > 0 {
+			p.status |= statusUnprocessed
+		}
+
+		if len(p.buf) == printerBufferSize {
+			p.status |= statusFull
+		}
+
+	case printerPrint:
+		// The third byte of the print command holds the palette.
+		var palette uint8
+		if len(p.data) >= 3 {
+			palette = p.data[2]
+		}
+
+		if err := p.print(palette); err != nil {
+			logger.Printf("printing failed: %s", err)
+		}
+
+		p.buf = p.buf[:0]
+		p.status = statusPrinting
+
+	case printerStatus:
+
+	default:
+		logger.Printf("unimplemented printer command 0x%02x", p.command)
+
+	}
+}
+
+// Decompress data, where each run starts with a control byte.
+// If bit 7 of the control byte is set, the next byte is repeated (control & 0x7f) + 2 times.
+// Otherwise, the next control + 1 bytes are copied as they are.
+func decompress(data []uint8) []uint8 {
+	var out []uint8
+
+	for i := 0; i < len(data); {
+		ctrl := data[i]
+		i++
+
+		if (ctrl & 0x80) != 0 {
+			if i >= len(data) {
+				break
+			}
+
+			for n := 0; n < int(ctrl&0x7f)+2; n++ {
+				out = append(out, data[i])
+			}
+			i++
+		} else {
+			end := i + int(ctrl) + 1
+			if end > len(data) {
+				end = len(data)
+			}
+
+			out = append(out, data[i:end]...)
+			i = end
+		}
+	}
+
+	return out
+}
+
+// Write the buffered tile data to the next PNG file, using the palette to shade each colour index.
+func (p *Printer) print(palette uint8) error {
+	// Each row of tiles is 20 tiles of 16 bytes.
+	rows := len(p.buf) / (printerWidth / 8 * 16)
+	if rows == 0 {
+		return nil
+	}
+
+	// A palette of 0 is treated as the default palette.
+	if palette == 0 {
+		palette = 0xe4
+	}
+
+	// The shades are opaque, unlike the colours drawn to screen.
+	shades := make(color.Palette, len(DefaultPalette))
+	for i, c := range DefaultPalette {
+		c.A = 0xff
+		shades[i] = c
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, printerWidth, rows*8), shades)
+
+	for t := 0; t < rows*printerWidth/8; t++ {
+		tx, ty := (t%(printerWidth/8))*8, (t/(printerWidth/8))*8
+
+		for y := 0; y < 8; y++ {
+			lo, hi := p.buf[t*16+y*2], p.buf[t*16+y*2+1]
+
+			for x := 0; x < 8; x++ {
+				index := (lo>>(7-x))&0x01 | ((hi>>(7-x))&0x01)<<1
+				img.SetColorIndex(tx+x, ty+y, (palette>>(index*2))&0x03)
+			}
+		}
+	}
+
+	f, err := p.create()
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Create the next image file, skipping any left by earlier runs so they aren't overwritten.
+func (p *Printer) create() (*os.File, error) {
+	for {
+		p.count++
+
+		f, err := os.OpenFile(filepath.Join(p.dir, fmt.Sprintf("print%03d.png", p.count)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
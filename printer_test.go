@@ -0,0 +1,145 @@
+package tamago
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Send a packet to the printer, returning its reply to the last two bytes.
+// If corrupt is true, the checksum is off by one.
+func sendPrinterPacket(p *Printer, command uint8, data []uint8, corrupt bool) (alive, status uint8) {
+	packet := []uint8{0x88, 0x33, command, 0x00, uint8(len(data)), uint8(len(data) >> 8)}
+	packet = append(packet, data...)
+
+	var sum uint16
+	for _, b := range packet[2:] {
+		sum += uint16(b)
+	}
+
+	if corrupt {
+		sum++
+	}
+
+	packet = append(packet, uint8(sum), uint8(sum>>8))
+
+	for _, b := range packet {
+		p.Transfer(b)
+	}
+
+	return p.Transfer(0x00), p.Transfer(0x00)
+}
+
+func testPrinter(t *testing.T) (*Printer, string) {
+	dir := filepath.Join(t.TempDir(), "prints")
+
+	p, err := NewPrinter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, dir
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []uint8
+		expected []uint8
+	}{
+		{"raw", []uint8{0x02, 1, 2, 3}, []uint8{1, 2, 3}},
+		{"repeated", []uint8{0x81, 7}, []uint8{7, 7, 7}},
+		{"mixed", []uint8{0x80, 5, 0x00, 6}, []uint8{5, 5, 6}},
+		{"truncated raw", []uint8{0x03, 1}, []uint8{1}},
+		{"truncated repeat", []uint8{0x81}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if out := decompress(tt.data); !bytes.Equal(out, tt.expected) {
+				t.Errorf("decompressed to %v, expected %v", out, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPrinterStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		command uint8
+		data    []uint8
+		corrupt bool
+		status  uint8
+	}{
+		{"init", printerInit, nil, false, 0x00},
+		{"status", printerStatus, nil, false, 0x00},
+		{"data", printerData, make([]uint8, 0x280), false, statusUnprocessed},
+		{"full", printerData, make([]uint8, printerBufferSize), false, statusUnprocessed | statusFull},
+		{"checksum error", printerData, make([]uint8, 0x10), true, statusChecksum},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := testPrinter(t)
+
+			alive, status := sendPrinterPacket(p, tt.command, tt.data, tt.corrupt)
+
+			if alive != 0x81 {
+				t.Errorf("printer replied 0x%02x, expected 0x81", alive)
+			}
+
+			if status != tt.status {
+				t.Errorf("status is 0x%02x, expected 0x%02x", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestPrinterPrint(t *testing.T) {
+	p, dir := testPrinter(t)
+
+	// An image left by an earlier run is skipped.
+	if err := os.WriteFile(filepath.Join(dir, "print001.png"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two rows of tiles, where every pixel is colour 3.
+	data := bytes.Repeat([]uint8{0xff}, 0x280)
+
+	sendPrinterPacket(p, printerInit, nil, false)
+	sendPrinterPacket(p, printerData, data, false)
+
+	if _, status := sendPrinterPacket(p, printerPrint, []uint8{0x01, 0x00, 0xe4, 0x40}, false); status != statusPrinting {
+		t.Errorf("status is 0x%02x after printing, expected 0x%02x", status, statusPrinting)
+	}
+
+	if _, status := sendPrinterPacket(p, printerStatus, nil, false); status != 0x00 {
+		t.Errorf("status is 0x%02x after it was read, expected 0x00", status)
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, "print001.png")); err != nil || info.Size() != 0 {
+		t.Error("earlier image was overwritten")
+	}
+
+	f, err := os.Open(filepath.Join(dir, "print002.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size := img.Bounds().Size(); size.X != printerWidth || size.Y != 16 {
+		t.Errorf("image is %dx%d, expected %dx16", size.X, size.Y, printerWidth)
+	}
+
+	c := DefaultPalette[3]
+	if r, g, b, _ := img.At(0, 0).RGBA(); uint8(r>>8) != c.R || uint8(g>>8) != c.G || uint8(b>>8) != c.B {
+		t.Errorf("pixel is %v, expected %v", img.At(0, 0), c)
+	}
+}
@@ -2,11 +2,27 @@ package tamago
 
 import (
 	"image/color"
+	"sort"
 )
 
 const (
 	renderWidth  = 160
 	renderHeight = 144
+
+	// The maximum number of sprites drawn on a scanline.
+	maxSprites = 10
+)
+
+// Renderer selects how the PPU draws scanlines.
+type Renderer uint8
+
+const (
+	// ScanlineRenderer draws each scanline all at once at the end of mode 3, which always takes the same time.
+	ScanlineRenderer Renderer = iota
+
+	// FIFORenderer draws each scanline pixel by pixel with a pixel fetcher and FIFOs, like the hardware.
+	// This is slower, but mid-scanline register writes take effect at the right pixel.
+	FIFORenderer
 )
 
 var mode = struct {
@@ -18,18 +34,52 @@ var mode = struct {
 // Render draws tiles from VRAM onto a screen.
 // sx and sy are the offsets of the display (size 160x144)
 // from (0,0) at the top left of the background map (size 256x256).
+// wx and wy are the position of the window's top left corner on the display, where wx is offset by 7.
 type Render struct {
 	sx, sy, mode, line uint8
+	wx, wy             uint8
 	tick               int
 	lcdc               *Bits
 
-	tileset    Tileset
+	renderer Renderer
+	fifo     *pixelFIFO
+
+	// The length of the current HBlank, which is shorter if mode 3 took longer.
+	hblank int
+
+	// The first frame after the LCD is turned on isn't displayed.
+	skip bool
+
+	// STAT holds the interrupt source selects, and LYC is compared against LY.
+	// statLine is the combined state of all selected STAT interrupt sources.
+	stat     Bits
+	lyc      uint8
+	statLine bool
+
+	// The window keeps its own line counter, which only advances on scanlines the window was drawn on.
+	// The window can only be drawn once LY has been equal to WY in the current frame.
+	windowLine uint8
+	windowY    bool
+
+	// In CGB mode, there is a tileset for each VRAM bank.
+	tileset    [2]Tileset
 	spriteData SpriteData
 
 	bg, obj0, obj1 Palette
 
+	// In CGB mode, the background and sprites each have 8 palettes of 15-bit colours in palette RAM,
+	// which is accessed through the BCPS/BCPD and OCPS/OCPD registers.
+	// https://gbdev.io/pandocs/Palettes.html#lcd-color-palettes-cgb-only
+	cgb                 bool
+	bcps, ocps          uint8
+	bgRAM, objRAM       [64]uint8
+	bgColour, objColour [8]Palette
+
 	fb *Framebuffer
 
+	// In SGB mode, the SGB colourises the screen.
+	sgb *SGB
+
 	// Put here so interrupts can be requested by the render.
 	intr *Interrupt
 
@@ -37,22 +87,41 @@ type Render struct {
 	oam  []uint8
 }
 
-func NewRender(vram, oam []uint8) *Render {
-	return &Render{
+func NewRender(vram, oam []uint8, intr *Interrupt) *Render {
+	r := &Render{
 		lcdc: &Bits{0},
 		bg:   DefaultPalette,
 		obj0: DefaultPalette,
 		obj1: DefaultPalette,
 		fb:   NewFramebuffer(renderWidth, renderHeight),
-		intr: NewInterrupt(),
+		intr: intr,
 		vram: vram,
 		oam:  oam,
 	}
+	r.fifo = newPixelFIFO(r)
+
+	// Palette RAM starts out white.
+	for i := 0; i < 64; i += 2 {
+		r.bgRAM[i], r.bgRAM[i+1] = 0xff, 0x7f
+		r.objRAM[i], r.objRAM[i+1] = 0xff, 0x7f
+	}
+
+	for i := range r.bgColour {
+		for j := range r.bgColour[i] {
+			r.bgColour[i][j] = colour15(0x7fff)
+			r.objColour[i][j] = colour15(0x7fff)
+		}
+	}
+
+	return r
 }
 
-// Given an offset in VRAM, update the tileset.
+// Given an offset in VRAM (where bank 1 starts at 0x2000), update the tileset.
 func (r *Render) updateTile(offset uint16) {
-	index := offset / 16
+	// Each row of a tile is two bytes, starting on an even offset.
+	offset &^= 1
+	bank := offset / 0x2000
+	index := (offset % 0x2000) / 16
 
 	lo := Bits{r.vram[offset]}
 	hi := Bits{r.vram[offset+1]}
@@ -70,7 +139,7 @@ func (r *Render) updateTile(offset uint16) {
 			pixel += 2
 		}
 
-		r.tileset[index][y][x] = pixel
+		r.tileset[bank][index][y][x] = pixel
 	}
 }
 
@@ -110,110 +179,257 @@ func (r *Render) updatePalette(p *Palette, v uint8) {
 	}
 }
 
-// Return the VRAM offset of the current tile.
-func (r *Render) offset() uint16 {
-	var base uint16
+// Return the VRAM offset of a tile map, selected by a LCDC bit (3 for the background, 6 for the window).
+func (r *Render) tileMap(bit int) uint16 {
+	if r.lcdc.At(bit) {
+		return 0x1c00
+	}
+
+	return 0x1800
+}
+
+// Return the colour index and attributes of the pixel at (x, y) in the tile map at base.
+func (r *Render) mapPixel(base uint16, x, y uint8) (uint8, Bits) {
+	// Since each tile is 8x8 pixels and the map is 32x32 tiles, the offset of the tile reference is:
+	// map offset + (y / 8) * 32 + (x / 8)
+	offset := base + uint16(y/8)*32 + uint16(x/8)
+	attr := r.attributes(offset)
+
+	return r.tileRow(r.vram[offset], attr, y%8)[x%8], attr
+}
+
+// Return the attributes of the tile at the tile map offset, which are in VRAM bank 1 in CGB mode.
+// https://gbdev.io/pandocs/Tile_Maps.html#bg-map-attributes-cgb-mode-only
+func (r *Render) attributes(offset uint16) Bits {
+	if !r.cgb {
+		return Bits{0}
+	}
+
+	return Bits{r.vram[0x2000+offset]}
+}
+
+// Return the colour indexes of row y of a background/window tile, from left to right.
+func (r *Render) tileRow(n uint8, attr Bits, y uint8) [8]uint8 {
+	// y-flip
+	if attr.At(6) {
+		y = 7 - y
+	}
+
+	row := r.tileset[tobit(attr.At(3))][r.tileIndex(n)][y]
+
+	// x-flip
+	if attr.At(5) {
+		for i := 0; i < 4; i++ {
+			row[i], row[7-i] = row[7-i], row[i]
+		}
+	}
+
+	return row
+}
+
+// Return the palette of a background/window tile with the attributes.
+func (r *Render) bgPalette(attr Bits) *Palette {
+	if r.cgb {
+		return &r.bgColour[attr.uint8&0x07]
+	}
+
+	return &r.bg
+}
+
+// Return the palette of a sprite.
+func (r *Render) objPalette(sprite *Sprite) *Palette {
+	switch {
+
+	case r.cgb:
+		return &r.objColour[sprite.options.uint8&0x07]
+
+	case sprite.options.At(4):
+		return &r.obj1
+
+	}
+
+	return &r.obj0
+}
+
+// Check if a background/window pixel with the colour index is drawn over a sprite pixel.
+// tile and sprite are the priority bits of the tile's attributes and the sprite's options.
+func (r *Render) bgOver(index uint8, tile, sprite bool) bool {
+	// Colour index 0 is always behind sprites.
+	if index == 0 {
+		return false
+	}
 
-	// Get the background map's base address.
-	if r.lcdc.At(3) {
-		base = 0x1c00
-	} else {
-		base = 0x1800
+	// In CGB mode, clearing LCDC bit 0 puts sprites over everything, and otherwise either priority bit is enough.
+	if r.cgb {
+		return r.lcdc.At(0) && (tile || sprite)
 	}
 
-	// Since each tile is 8x8 pixels, the address of the tile reference in VRAM is:
-	// map offset + ((y offset + display offset) / 8) + (x offset / 8)
-	// because all offsets are in pixels.
-	return base + uint16((r.sy+r.line)/8) + uint16(r.sx/8)
+	return sprite
 }
 
-// Return the tile pointed to by the VRAM offset.
-func (r *Render) tile(offset uint16) Tile {
-	idx := int(r.vram[offset])
+// Convert a background/window tile number into an index in the tileset.
+// If LCDC bit 4 is clear, tiles 0-127 are taken from 0x9000-0x97ff instead of 0x8000-0x87ff.
+func (r *Render) tileIndex(n uint8) int {
+	idx := int(n)
 
 	if !r.lcdc.At(4) && idx < 128 {
 		idx += 256
 	}
 
-	return r.tileset[idx]
+	return idx
+}
+
+// Return the colour indexes of the sprite's row on the current scanline, from left to right.
+func (r *Render) spriteRow(sprite *Sprite) [8]uint8 {
+	height := r.spriteHeight()
+
+	rowIndex := int(r.line) - (int(sprite.y) - 16)
+
+	// y-flip
+	if sprite.options.At(6) {
+		rowIndex = height - 1 - rowIndex
+	}
+
+	// In 8x16 mode, the top tile is at the even index and the bottom tile at the odd index.
+	tile := int(sprite.tile)
+	if height == 16 {
+		tile &^= 1
+	}
+
+	// In CGB mode, the tile can be in either VRAM bank.
+	bank := 0
+	if r.cgb && sprite.options.At(3) {
+		bank = 1
+	}
+
+	row := r.tileset[bank][tile+rowIndex/8][rowIndex%8]
+
+	// x-flip
+	if sprite.options.At(5) {
+		for i := 0; i < 4; i++ {
+			row[i], row[7-i] = row[7-i], row[i]
+		}
+	}
+
+	return row
+}
+
+// Return the height of all sprites, which is 16 if LCDC bit 2 is set and 8 otherwise.
+func (r *Render) spriteHeight() int {
+	if r.lcdc.At(2) {
+		return 16
+	}
+
+	return 8
+}
+
+// Return the indexes of the sprites on the current scanline, from highest to lowest priority.
+func (r *Render) scanSprites() []int {
+	height := r.spriteHeight()
+	line := int(r.line)
+
+	// Like the hardware's OAM scan, only the first 10 sprites in OAM on the scanline are selected.
+	// This includes sprites that are off-screen horizontally.
+	selected := make([]int, 0, maxSprites)
+	for i := range r.spriteData {
+		top := int(r.spriteData[i].y) - 16
+
+		if top <= line && line < top+height {
+			selected = append(selected, i)
+
+			if len(selected) == maxSprites {
+				break
+			}
+		}
+	}
+
+	// In CGB mode, sprites earlier in OAM have priority.
+	if r.cgb {
+		return selected
+	}
+
+	// On the DMG, sprites with a smaller X coordinate have priority.
+	// If the X coordinates are the same, the sprite earlier in OAM has priority.
+	sort.SliceStable(selected, func(a, b int) bool {
+		return r.spriteData[selected[a]].x < r.spriteData[selected[b]].x
+	})
+
+	return selected
 }
 
 // Render the next scanline on screen.
 func (r *Render) scanline() {
-	var scanline [160]*color.RGBA
+	var scanline [renderWidth]*color.RGBA
+
+	// The colour index and priority bit of each background/window pixel, before applying the palette.
+	var bgIndex [renderWidth]uint8
+	var bgPriority [renderWidth]bool
 
 	dy := int(r.line)
 
 	// background enable
-	if r.lcdc.At(0) {
-		offset := r.offset()
-		tile := r.tile(offset)
+	// On the DMG, this also disables the window. In CGB mode, the background and window are always drawn.
+	if r.lcdc.At(0) || r.cgb {
+		bgMap := r.tileMap(3)
+		winMap := r.tileMap(6)
 
-		// Calculate the x and y offset of the current pixel in the tile.
-		y := (r.sy + r.line) % 8
-		x := r.sx % 8
+		// The window starts at WX - 7, so when WX < 7 the leftmost pixels of the window are cut off.
+		winX := int(r.wx) - 7
+		window := r.lcdc.At(5) && r.windowY && winX < renderWidth
 
 		for dx := range scanline {
-			scanline[dx] = &r.bg[tile[y][x]]
-
-			x++
-			if x == 8 {
-				// Reached the end of this tile, get the tile referred to by the next offset.
-				x = 0
-				offset++
-				tile = r.tile(offset)
+			var index uint8
+			var attr Bits
+
+			if window && dx >= winX {
+				index, attr = r.mapPixel(winMap, uint8(dx-winX), r.windowLine)
+			} else {
+				// The background map wraps around horizontally and vertically.
+				index, attr = r.mapPixel(bgMap, r.sx+uint8(dx), r.sy+r.line)
 			}
+
+			bgIndex[dx] = index
+			bgPriority[dx] = attr.At(7)
+			scanline[dx] = &r.bgPalette(attr)[index]
+		}
+
+		if window {
+			r.windowLine++
 		}
 	}
 
 	// object enable
 	if r.lcdc.At(1) {
-		for i := 0; i < 40; i++ {
-			sprite := r.spriteData[i]
+		// Pixels already covered by a sprite with higher priority.
+		var covered [renderWidth]bool
 
-			var sx, sy int
-			sx = int(sprite.x) - 8
-			sy = int(sprite.y) - 16
+		for _, i := range r.scanSprites() {
+			sprite := &r.spriteData[i]
 
-			if sy <= dy && (sy+8) > dy {
-				var p Palette
-
-				// palette select
-				if sprite.options.At(4) {
-					p = r.obj1
-				} else {
-					p = r.obj0
-				}
+			p := r.objPalette(sprite)
 
-				var rowIndex int
+			row := r.spriteRow(sprite)
+			sx := int(sprite.x) - 8
 
-				// y-flip
-				if sprite.options.At(6) {
-					rowIndex = 7 - (dy - sy)
-				} else {
-					rowIndex = dy - sy
+			for x, colour := range row {
+				pos := sx + x
+				if pos < 0 || pos >= renderWidth || covered[pos] {
+					continue
 				}
-				row := r.tileset[sprite.tile][rowIndex]
 
-				for x := 0; x < 8; x++ {
-					pos := sx + x
-
-					if pos >= 0 && pos < 160 && (!sprite.options.At(7) || *scanline[pos] == White) {
-
-						index := x
-						if sprite.options.At(5) {
-							index = 7 - x
-						}
-
-						colour := p[row[index]]
-						if colour != White {
-							scanline[sx] = &colour
-						}
+				// Colour index 0 is transparent.
+				if colour == 0 {
+					continue
+				}
 
-						sx++
+				// Even if the background is drawn over this sprite, sprites with lower priority are still hidden.
+				covered[pos] = true
 
-					}
+				if r.bgOver(bgIndex[pos], bgPriority[pos], sprite.options.At(7)) {
+					continue
 				}
+
+				scanline[pos] = &p[colour]
 			}
 		}
 	}
@@ -222,27 +438,41 @@ func (r *Render) scanline() {
 		if colour == nil {
 			colour = &White
 		}
-		r.fb.Write(dx, dy, colour)
+		r.draw(dx, dy, colour)
 	}
 
 }
 
-// Given the emulation state clock, update the render.
-func (r *Render) step(c *Clock) {
-	r.tick += c.t
+// Given the number of cycles run, update the render.
+func (r *Render) step(cycles int) {
+	// The PPU is stopped while the LCD is off.
+	if !r.enabled() {
+		return
+	}
+
+	r.tick += cycles
 
 	switch r.mode {
 
 	case mode.HBlank:
-		if r.tick >= 204 {
-			r.tick = 0
+		if r.tick >= r.hblank {
+			r.tick -= r.hblank
 			r.line++
 
-			if r.line == 143 {
+			if r.line == renderHeight {
 				// last scanline, vblank
 				r.mode = mode.VBlank
 				r.intr.requested |= VBlank
 
+				// The frame is complete, whether or not the VBlank interrupt is serviced.
+				if !r.skip {
+					r.fb.Publish()
+				}
+
+				if r.sgb != nil {
+					r.sgb.vblank()
+				}
+
 			} else {
 				r.mode = mode.OAM
 			}
@@ -250,28 +480,188 @@ func (r *Render) step(c *Clock) {
 
 	case mode.VBlank:
 		if r.tick >= 456 {
-			r.tick = 0
+			r.tick -= 456
 			r.line++
 
-			if r.line >= 153 {
+			// VBlank lasts for 10 scanlines (144-153).
+			if r.line > 153 {
 				r.mode = mode.OAM
 				r.line = 0
+				r.skip = false
+
+				r.windowLine = 0
+				r.windowY = false
 			}
 		}
 
 	case mode.OAM:
 		if r.tick >= 80 {
-			r.tick = 0
+			r.tick -= 80
 			r.mode = mode.VRAM
+
+			if r.line == r.wy {
+				r.windowY = true
+			}
+
+			if r.renderer == FIFORenderer {
+				r.fifo.start()
+			}
 		}
 
 	case mode.VRAM:
-		if r.tick >= 172 {
-			r.tick = 0
-			r.mode = 0
+		if r.renderer == FIFORenderer {
+			// Run the FIFO dot by dot, until the whole scanline has been drawn.
+			for r.tick > 0 && !r.fifo.done {
+				r.fifo.tick()
+				r.tick--
+			}
+
+			if r.fifo.done {
+				r.mode = mode.HBlank
+				r.hblank = 376 - r.fifo.length
+			}
+
+		} else if r.tick >= 172 {
+			r.tick -= 172
+			r.mode = mode.HBlank
+			r.hblank = 204
 
 			r.scanline()
 		}
 
 	}
+
+	r.updateStat()
+}
+
+// Draw a pixel on screen.
+func (r *Render) draw(x, y int, c *color.RGBA) {
+	if r.sgb != nil {
+		if c = r.sgb.colour(x, y, shadeOf(c)); c == nil {
+			return
+		}
+	}
+
+	r.fb.Write(x, y, c)
+}
+
+// Check if the LCD and PPU are on (LCDC bit 7).
+func (r *Render) enabled() bool {
+	return r.lcdc.At(7)
+}
+
+// Check if the CPU is blocked from accessing addr because the PPU is using it.
+// OAM can't be accessed during modes 2 and 3, and VRAM can't be accessed during mode 3.
+// https://gbdev.io/pandocs/Accessing_VRAM_and_OAM.html
+func (r *Render) blocks(addr uint16) bool {
+	if !r.enabled() {
+		return false
+	}
+
+	switch {
+
+	case isVRAM(addr):
+		return r.mode == mode.VRAM
+
+	case addr >= 0xfe00 && addr <= 0xfe9f:
+		return r.mode == mode.OAM || r.mode == mode.VRAM
+
+	// Palette RAM can't be accessed during mode 3 either.
+	case addr == 0xff69 || addr == 0xff6b:
+		return r.cgb && r.mode == mode.VRAM
+
+	}
+
+	return false
+}
+
+// Write to the LCDC register, turning the LCD on or off if bit 7 changed.
+func (r *Render) writeLCDC(val uint8) {
+	was := r.enabled()
+	r.lcdc.uint8 = val
+
+	switch on := r.enabled(); {
+
+	// When the LCD is turned off, LY and the mode are reset to 0 and the screen goes blank.
+	case was && !on:
+		r.line = 0
+		r.mode = mode.HBlank
+		r.tick = 0
+
+		r.windowLine = 0
+		r.windowY = false
+
+		r.fb.Clear(&White)
+		r.fb.Publish()
+
+	// When the LCD is turned on, the PPU starts from line 0, but the first frame is not displayed.
+	case !was && on:
+		r.line = 0
+		r.mode = mode.OAM
+		r.tick = 0
+		r.skip = true
+
+	}
+
+	r.updateStat()
+}
+
+// Return the value of the STAT register.
+func (r *Render) readStat() uint8 {
+	// Bit 7 is unused and always set.
+	v := r.stat.uint8 | 0x80 | r.mode
+
+	if r.line == r.lyc {
+		v |= 0x04
+	}
+
+	return v
+}
+
+// Write to the STAT register, where only the interrupt source selects (bits 3-6) are writable.
+func (r *Render) writeStat(val uint8) {
+	r.stat.uint8 = val & 0x78
+	r.updateStat()
+}
+
+// Write to the LYC register.
+func (r *Render) writeLYC(val uint8) {
+	r.lyc = val
+	r.updateStat()
+}
+
+// Update the STAT interrupt line.
+// All selected interrupt sources are ORed together into one line, so an interrupt is only requested when it rises from low to high.
+// https://gbdev.io/pandocs/Interrupt_Sources.html#int-48--stat-interrupt
+func (r *Render) updateStat() {
+	line := (r.stat.At(6) && r.line == r.lyc) ||
+		(r.stat.At(5) && r.mode == mode.OAM) ||
+		(r.stat.At(4) && r.mode == mode.VBlank) ||
+		(r.stat.At(3) && r.mode == mode.HBlank)
+
+	if line && !r.statLine {
+		r.intr.requested |= LCDStat
+	}
+
+	r.statLine = line
+}
+
+// Read from palette RAM at the index in a palette specification register (BCPS or OCPS).
+func readPalette(spec uint8, ram *[64]uint8) uint8 {
+	return ram[spec&0x3f]
+}
+
+// Write to palette RAM at the index in a palette specification register (BCPS or OCPS),
+// incrementing the index afterwards if bit 7 of the register is set.
+func writePalette(spec *uint8, ram *[64]uint8, palettes *[8]Palette, val uint8) {
+	index := *spec & 0x3f
+	ram[index] = val
+
+	// Each colour is two bytes, little-endian, and each palette has four colours.
+	i := index &^ 1
+	palettes[i/8][(i%8)/2] = colour15(uint16(ram[i]) | uint16(ram[i+1])<<8)
+
+	if (*spec & 0x80) != 0 {
+		*spec = 0x80 | ((index + 1) & 0x3f)
+	}
 }
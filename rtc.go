@@ -0,0 +1,268 @@
+package tamago
+
+import (
+	"time"
+)
+
+// RTC registers, as selected by the MBC3's RAM bank number.
+const (
+	rtcSeconds uint8 = 0x08 + iota
+	rtcMinutes
+	rtcHours
+	rtcDaysLo
+	rtcDaysHi
+)
+
+// The size of the clock data appended to save files.
+// This is the same format used by other emulators (i.e BGB, VBA-M), so saves are interchangeable:
+// the current and latched registers as 32-bit integers, followed by a 64-bit UNIX timestamp of when the save was made.
+const (
+	rtcSaveSize       = 48
+	rtcSaveSizeLegacy = 44
+)
+
+// RTC is the real time clock in some MBC3 cartridges.
+// By default, the clock advances with emulated time.
+// https://gbdev.io/pandocs/MBC3.html#the-clock-counter-registers
+type RTC struct {
+	s, m, h uint8
+	days    uint16
+
+	halt, carry bool
+
+	// The registers as copied by the last latch.
+	latched [5]uint8
+
+	// The last value written to the latch register.
+	latch uint8
+
+	// Cycles run since the last second, for emulated time.
+	cycles int
+
+	// If wallclock is true, the clock follows the host's wall clock instead of emulated time.
+	// last is the time the registers were last brought up to date.
+	wallclock bool
+	last      time.Time
+
+	// True if the clock has changed since it was last saved.
+	dirty bool
+}
+
+func NewRTC() *RTC {
+	return &RTC{latch: 0xff}
+}
+
+// Set whether the clock follows the host's wall clock.
+// Emulated time is used otherwise, so the clock stops when the emulator isn't running.
+//
+// If a save with clock data was restored beforehand, the time elapsed since the save was made is caught up on.
+func (r *RTC) SetWallClock(enabled bool) {
+	r.wallclock = enabled
+
+	if r.last.IsZero() {
+		r.last = time.Now()
+	}
+}
+
+// Given the number of cycles run, advance the clock.
+func (r *RTC) step(cycles int) {
+	if r.wallclock || r.halt {
+		return
+	}
+
+	r.cycles += cycles
+	if r.cycles >= cps {
+		r.advance(int64(r.cycles / cps))
+		r.cycles %= cps
+	}
+}
+
+// Catch the clock up to the host's wall clock, if enabled.
+func (r *RTC) sync() {
+	if !r.wallclock {
+		return
+	}
+
+	secs := time.Since(r.last) / time.Second
+	r.last = r.last.Add(secs * time.Second)
+
+	if !r.halt {
+		r.advance(int64(secs))
+	}
+}
+
+// Advance the clock by n seconds.
+func (r *RTC) advance(n int64) {
+	if n > 0 {
+		r.dirty = true
+	}
+
+	// Registers written out of range count up to their wrap without carrying, so step a second at a time until they are back in range.
+	for ; n > 0 && (r.s >= 60 || r.m >= 60 || r.h >= 24); n-- {
+		r.tick()
+	}
+
+	if n <= 0 {
+		return
+	}
+
+	total := int64(r.s) + int64(r.m)*60 + int64(r.h)*3600 + int64(r.days)*86400 + n
+
+	r.s = uint8(total % 60)
+	total /= 60
+
+	r.m = uint8(total % 60)
+	total /= 60
+
+	r.h = uint8(total % 24)
+	total /= 24
+
+	// The day counter is 9 bits wide, and sets the carry bit when it overflows.
+	if total > 0x1ff {
+		r.carry = true
+		total &= 0x1ff
+	}
+
+	r.days = uint16(total)
+}
+
+// Advance the clock by a second.
+// Each register only carries into the next when it rolls over from its last in-range value,
+// otherwise it wraps at its width (6 bits for seconds and minutes, 5 bits for hours).
+func (r *RTC) tick() {
+	r.s = (r.s + 1) & 0x3f
+	if r.s != 60 {
+		return
+	}
+	r.s = 0
+
+	r.m = (r.m + 1) & 0x3f
+	if r.m != 60 {
+		return
+	}
+	r.m = 0
+
+	r.h = (r.h + 1) & 0x1f
+	if r.h != 24 {
+		return
+	}
+	r.h = 0
+
+	r.days++
+	if r.days > 0x1ff {
+		r.carry = true
+		r.days = 0
+	}
+}
+
+// Write to the latch register.
+// Writing 0x00 and then 0x01 copies the current time into the latched registers.
+func (r *RTC) writeLatch(val uint8) {
+	if r.latch == 0x00 && val == 0x01 {
+		r.sync()
+
+		r.latched = [5]uint8{r.s, r.m, r.h, uint8(r.days), r.daysHi()}
+		r.dirty = true
+	}
+
+	r.latch = val
+}
+
+// Return the upper day counter register (bit 0 of the day counter, the halt flag and the carry flag).
+func (r *RTC) daysHi() uint8 {
+	v := uint8(r.days>>8) & 0x01
+
+	if r.halt {
+		v |= 0x40
+	}
+
+	if r.carry {
+		v |= 0x80
+	}
+
+	return v
+}
+
+// Read a latched register.
+func (r *RTC) read(reg uint8) uint8 {
+	return r.latched[reg-rtcSeconds]
+}
+
+// Write to a register, which sets the clock directly.
+func (r *RTC) write(reg uint8, val uint8) {
+	r.sync()
+	r.dirty = true
+
+	switch reg {
+
+	case rtcSeconds:
+		r.s = val & 0x3f
+		r.cycles = 0
+
+	case rtcMinutes:
+		r.m = val & 0x3f
+
+	case rtcHours:
+		r.h = val & 0x1f
+
+	case rtcDaysLo:
+		r.days = (r.days & 0x100) | uint16(val)
+
+	case rtcDaysHi:
+		r.days = (r.days & 0xff) | uint16(val&0x01)<<8
+		r.halt = (val & 0x40) != 0
+		r.carry = (val & 0x80) != 0
+
+	}
+}
+
+// Return the clock data to append to a save file.
+func (r *RTC) save() []uint8 {
+	r.sync()
+
+	buf := make([]uint8, rtcSaveSize)
+
+	regs := [5]uint8{r.s, r.m, r.h, uint8(r.days), r.daysHi()}
+	for i, v := range regs {
+		Endian.PutUint32(buf[i*4:], uint32(v))
+	}
+
+	for i, v := range r.latched {
+		Endian.PutUint32(buf[20+i*4:], uint32(v))
+	}
+
+	now := r.last
+	if !r.wallclock {
+		now = time.Now()
+	}
+
+	Endian.PutUint64(buf[40:], uint64(now.Unix()))
+
+	return buf
+}
+
+// Restore the clock from the data at the end of a save file.
+func (r *RTC) restore(buf []uint8) {
+	var regs [5]uint8
+	for i := range regs {
+		regs[i] = uint8(Endian.Uint32(buf[i*4:]))
+	}
+
+	r.s, r.m, r.h = regs[0], regs[1], regs[2]
+	r.days = uint16(regs[3]) | uint16(regs[4]&0x01)<<8
+	r.halt = (regs[4] & 0x40) != 0
+	r.carry = (regs[4] & 0x80) != 0
+
+	for i := range r.latched {
+		r.latched[i] = uint8(Endian.Uint32(buf[20+i*4:]))
+	}
+
+	var unix int64
+	if len(buf) >= rtcSaveSize {
+		unix = int64(Endian.Uint64(buf[40:]))
+	} else {
+		unix = int64(Endian.Uint32(buf[40:]))
+	}
+
+	r.last = time.Unix(unix, 0)
+}
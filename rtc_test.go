@@ -0,0 +1,44 @@
+package tamago
+
+import "testing"
+
+func TestRTCAdvance(t *testing.T) {
+	tests := []struct {
+		name    string
+		s, m, h uint8
+		days    uint16
+		n       int64
+
+		// The registers after advancing.
+		es, em, eh uint8
+		edays      uint16
+		carry      bool
+	}{
+		{"seconds", 10, 0, 0, 0, 5, 15, 0, 0, 0, false},
+		{"minute rollover", 59, 59, 0, 0, 1, 0, 0, 1, 0, false},
+		{"day rollover", 59, 59, 23, 0, 1, 0, 0, 0, 1, false},
+		{"day counter overflow", 59, 59, 23, 0x1ff, 1, 0, 0, 0, 0, true},
+		{"many days", 0, 0, 0, 0, 3*86400 + 3661, 1, 1, 1, 3, false},
+		{"seconds wrap without carry", 62, 5, 0, 0, 3, 1, 5, 0, 0, false},
+		{"minutes wrap without carry", 59, 63, 0, 0, 1, 0, 0, 0, 0, false},
+		{"hours wrap without carry", 59, 59, 31, 0, 1, 0, 0, 0, 0, false},
+		{"in range after wrapping", 63, 59, 23, 0, 61, 0, 0, 0, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRTC()
+			r.s, r.m, r.h, r.days = tt.s, tt.m, tt.h, tt.days
+
+			r.advance(tt.n)
+
+			if r.s != tt.es || r.m != tt.em || r.h != tt.eh || r.days != tt.edays {
+				t.Errorf("clock is %d days %02d:%02d:%02d, expected %d days %02d:%02d:%02d", r.days, r.h, r.m, r.s, tt.edays, tt.eh, tt.em, tt.es)
+			}
+
+			if r.carry != tt.carry {
+				t.Errorf("carry is %t, expected %t", r.carry, tt.carry)
+			}
+		})
+	}
+}
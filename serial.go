@@ -0,0 +1,221 @@
+package tamago
+
+const (
+	// With the internal clock, one bit is shifted every 512 cycles (8192 Hz).
+	serialBitCycles = 512
+
+	// In CGB mode, the fast clock shifts bits 32 times faster.
+	serialFastBitCycles = 16
+
+	// How long a transfer with the internal clock waits for a reply from an asynchronous device, before receiving 0xff.
+	serialWaitCycles = cps / 8
+)
+
+// SerialDevice is something connected to the serial port, such as another Game Boy over a link cable.
+// Bytes are exchanged with the device a whole byte at a time.
+type SerialDevice interface {
+	// Exchange a byte when the Game Boy provides the clock (internal clock), returning the byte sent back.
+	Transfer(out uint8) uint8
+
+	// Check if the device has clocked a transfer (external clock), exchanging out for the byte it sent if so.
+	// If the device hasn't started a transfer, ok is false.
+	Receive(out uint8) (in uint8, ok bool)
+}
+
+// asyncDevice is implemented by devices that reply some time after a transfer starts, such as a link cable.
+// Transfers with these devices don't block, so the emulator keeps running while waiting for the reply.
+type asyncDevice interface {
+	// Send a byte without waiting for the reply, returning false if it couldn't be sent.
+	start(out uint8) bool
+
+	// Check if the reply to the last byte sent has arrived.
+	poll() (in uint8, ok bool)
+
+	// Give up waiting for the reply.
+	cancel()
+}
+
+// disconnected is the device used when nothing is connected to the serial port.
+// With nothing to reply, the Game Boy receives 0xff, and nothing ever provides an external clock.
+type disconnected struct{}
+
+func (disconnected) Transfer(out uint8) uint8 {
+	return 0xff
+}
+
+func (disconnected) Receive(out uint8) (uint8, bool) {
+	return 0, false
+}
+
+// serial is the serial port (SB and SC), which shifts a byte out while shifting another byte in.
+// https://gbdev.io/pandocs/Serial_Data_Transfer_(Link_Cable).html
+type serial struct {
+	sb, sc uint8
+
+	// The byte being shifted in, the number of bits shifted so far, and the cycles since the last bit was shifted.
+	in     uint8
+	bits   int
+	cycles int
+
+	// The device being waited on for the byte to shift in, and the cycles waited so far.
+	waiting asyncDevice
+	wait    int
+
+	// The fast clock (SC bit 1) is only available in CGB mode.
+	cgb bool
+
+	device SerialDevice
+	intr   *Interrupt
+}
+
+func newSerial(intr *Interrupt) *serial {
+	return &serial{device: disconnected{}, intr: intr}
+}
+
+// Check if a transfer is running (SC bit 7).
+func (s *serial) active() bool {
+	return (s.sc & 0x80) != 0
+}
+
+// Check if the Game Boy provides the clock (SC bit 0).
+func (s *serial) internal() bool {
+	return (s.sc & 0x01) != 0
+}
+
+// Return the number of cycles between each bit shifted with the internal clock.
+func (s *serial) period() int {
+	if s.cgb && (s.sc&0x02) != 0 {
+		return serialFastBitCycles
+	}
+
+	return serialBitCycles
+}
+
+// Given the number of cycles run, shift bits or wait for the device to start a transfer.
+func (s *serial) step(cycles int) {
+	if !s.active() {
+		return
+	}
+
+	if !s.internal() {
+		// With the external clock, the whole byte is exchanged once the device has clocked it.
+		if in, ok := s.device.Receive(s.sb); ok {
+			s.sb = in
+			s.finish()
+		}
+
+		return
+	}
+
+	// Bits are only shifted once the byte to shift in has arrived.
+	if s.waiting != nil && !s.poll(cycles) {
+		return
+	}
+
+	s.cycles += cycles
+
+	for s.cycles >= s.period() && s.bits < 8 {
+		s.cycles -= s.period()
+
+		// Bits are shifted out and in from the most significant bit.
+		s.sb = s.sb<<1 | (s.in>>(7-s.bits))&0x01
+		s.bits++
+	}
+
+	if s.bits == 8 {
+		s.finish()
+	}
+}
+
+// Given the number of cycles run, check if the device has replied, returning true if the byte to shift in is known.
+// If the device doesn't reply in time, 0xff is shifted in like when nothing is connected.
+func (s *serial) poll(cycles int) bool {
+	if in, ok := s.waiting.poll(); ok {
+		s.in = in
+		s.waiting = nil
+
+		return true
+	}
+
+	s.wait += cycles
+	if s.wait < serialWaitCycles {
+		return false
+	}
+
+	s.cancel()
+
+	return true
+}
+
+// Stop waiting for the device to reply, if it is being waited on.
+func (s *serial) cancel() {
+	if s.waiting != nil {
+		s.waiting.cancel()
+		s.waiting = nil
+	}
+}
+
+// Finish a transfer, requesting the Serial interrupt.
+func (s *serial) finish() {
+	s.sc &^= 0x80
+	s.intr.requested |= Serial
+}
+
+func (s *serial) read(addr uint16) uint8 {
+	switch addr {
+
+	case 0xff01:
+		return s.sb
+
+	case 0xff02:
+		// Unused bits are always set, including the fast clock bit outside of CGB mode.
+		if s.cgb {
+			return s.sc | 0x7c
+		}
+
+		return s.sc | 0x7e
+
+	}
+
+	return 0xff
+}
+
+func (s *serial) write(addr uint16, val uint8) {
+	switch addr {
+
+	case 0xff01:
+		s.sb = val
+
+	case 0xff02:
+		s.sc = val & 0x83
+
+		// Writing SC abandons a transfer that is still waiting on the device.
+		s.cancel()
+
+		if !s.active() {
+			return
+		}
+
+		s.bits = 0
+		s.cycles = 0
+
+		if !s.internal() {
+			return
+		}
+
+		// With the internal clock, the byte is exchanged with the device straight away,
+		// but it is shifted into SB one bit at a time.
+		// Asynchronous devices are sent the byte now, and the reply is waited on in step.
+		if d, ok := s.device.(asyncDevice); ok {
+			s.in = 0xff
+			s.wait = 0
+
+			if d.start(s.sb) {
+				s.waiting = d
+			}
+		} else {
+			s.in = s.device.Transfer(s.sb)
+		}
+
+	}
+}
@@ -0,0 +1,567 @@
+package tamago
+
+import (
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+const (
+	// The size of the SGB's screen, where the game screen is surrounded by a border.
+	sgbWidth  = 256
+	sgbHeight = 224
+
+	// The position of the game screen within the border.
+	borderX = 48
+	borderY = 40
+
+	// The size of the attribute map, where each attribute selects the palette of an 8x8 area of the screen.
+	attrWidth  = renderWidth / 8
+	attrHeight = renderHeight / 8
+
+	// The number of bytes sent in each packet, and the number of packets in the longest command.
+	packetSize = 16
+	maxPackets = 7
+
+	// The number of bytes sent through the screen by VRAM transfer commands.
+	sgbTransferSize = 0x1000
+)
+
+// Commands sent by the game to the SGB.
+// https://gbdev.io/pandocs/SGB_Command_Summary.html
+const (
+	cmdPal01   = 0x00
+	cmdPal23   = 0x01
+	cmdPal03   = 0x02
+	cmdPal12   = 0x03
+	cmdAttrBlk = 0x04
+	cmdAttrLin = 0x05
+	cmdAttrDiv = 0x06
+	cmdAttrChr = 0x07
+	cmdPalSet  = 0x0a
+	cmdPalTrn  = 0x0b
+	cmdMltReq  = 0x11
+	cmdChrTrn  = 0x13
+	cmdPctTrn  = 0x14
+	cmdMaskEn  = 0x17
+)
+
+// How the game screen is masked by MASK_EN, while the game is setting up the next screen.
+const (
+	maskCancel = iota
+	maskFreeze
+	maskBlack
+	maskColour0
+)
+
+// SGB is the Super Game Boy, which colourises the game screen and surrounds it with a border.
+// The game sends it commands in packets through the joypad register, and larger data by drawing it on screen.
+// https://gbdev.io/pandocs/SGB_Functions.html
+type SGB struct {
+	// The packet being received bit by bit, and the previous value written to the joypad register.
+	packet    [packetSize]uint8
+	bit       int
+	receiving bool
+	last      uint8
+
+	// The packets of the command received so far, and the number of packets in the command.
+	data    [packetSize * maxPackets]uint8
+	packets int
+	length  int
+
+	// The four palettes used for the game screen, where colour 0 is shared by all palettes.
+	palettes [4]Palette
+
+	// The system palettes sent by PAL_TRN, which can be copied to the game screen's palettes by PAL_SET.
+	system [512]Palette
+
+	// The palette used for each 8x8 area of the game screen.
+	attrs [attrWidth * attrHeight]uint8
+
+	mask uint8
+
+	// The shade (0-3) of each pixel on the game screen, which is what the SGB sees.
+	shades [renderWidth * renderHeight]uint8
+
+	// A VRAM transfer command waiting for the next frame to be drawn.
+	transfer uint8
+	pending  bool
+
+	// The number of joypads selected by MLT_REQ, and the joypad currently being read.
+	players, player uint8
+
+	// The border's tiles (with 4 bits per pixel), tile map and palettes (4 to 7).
+	tiles      [256]Tile
+	tileMap    [32 * 28]uint16
+	borderPals [4][16]color.RGBA
+
+	// The border is only redrawn after it changes.
+	border *Framebuffer
+	image  *ebiten.Image
+	dirty  bool
+}
+
+func NewSGB() *SGB {
+	s := &SGB{
+		palettes: [4]Palette{DefaultPalette, DefaultPalette, DefaultPalette, DefaultPalette},
+		players:  1,
+		border:   NewFramebuffer(sgbWidth, sgbHeight),
+		dirty:    true,
+	}
+
+	return s
+}
+
+// Handle a write to the joypad register, receiving packets bit by bit.
+// Writing 0 to both P14 and P15 starts a packet, and pulsing P14 or P15 low sends a 0 or 1 bit.
+// https://gbdev.io/pandocs/SGB_Functions.html#command-packet-transfers
+func (s *SGB) write(val uint8) {
+	p := val & 0x30
+
+	// In multiplayer mode, P15 going high selects the next joypad.
+	if s.players > 1 && !s.receiving && (s.last&0x20) == 0 && (p&0x20) != 0 {
+		s.player = (s.player + 1) % s.players
+	}
+
+	switch {
+
+	case p == 0x00:
+		s.receiving = true
+		s.bit = 0
+		s.packet = [packetSize]uint8{}
+
+	// Bits are only sent after both lines were high.
+	case !s.receiving || s.last != 0x30 || p == 0x30:
+
+	default:
+		if p == 0x10 {
+			s.packet[s.bit/8] |= 1 << (s.bit % 8)
+		}
+
+		s.bit++
+		if s.bit == packetSize*8 {
+			s.receiving = false
+			s.receive()
+		}
+
+	}
+
+	s.last = p
+}
+
+// Return the value of the joypad register, which returns the current joypad in multiplayer mode if neither P14 or P15 is low.
+func (s *SGB) poll(v uint8) uint8 {
+	if s.players > 1 && s.last == 0x30 {
+		return 0xf0 | (0x0f - s.player)
+	}
+
+	return v
+}
+
+// Add a received packet to the current command, running the command once all its packets are received.
+func (s *SGB) receive() {
+	// The first packet holds the number of packets in the command.
+	if s.packets == 0 {
+		s.length = int(s.packet[0] & 0x07)
+		if s.length == 0 {
+			s.length = 1
+		}
+	}
+
+	copy(s.data[s.packets*packetSize:], s.packet[:])
+	s.packets++
+
+	if s.packets == s.length {
+		s.packets = 0
+		s.command()
+	}
+}
+
+// Run the command in the received packets.
+func (s *SGB) command() {
+	d := s.data[:]
+
+	switch cmd := d[0] >> 3; cmd {
+
+	case cmdPal01:
+		s.setPalettes(0, 1, d)
+
+	case cmdPal23:
+		s.setPalettes(2, 3, d)
+
+	case cmdPal03:
+		s.setPalettes(0, 3, d)
+
+	case cmdPal12:
+		s.setPalettes(1, 2, d)
+
+	case cmdAttrBlk:
+		s.attrBlock(d)
+
+	case cmdAttrLin:
+		s.attrLine(d)
+
+	case cmdAttrDiv:
+		s.attrDivide(d)
+
+	case cmdAttrChr:
+		s.attrChar(d)
+
+	case cmdPalSet:
+		for i := range s.palettes {
+			n := (uint16(d[1+i*2]) | uint16(d[2+i*2])<<8) & 0x1ff
+			s.palettes[i] = s.system[n]
+		}
+
+		s.shareColour0(s.palettes[0][0])
+
+		// Bit 6 cancels the mask.
+		if (d[9] & 0x40) != 0 {
+			s.mask = maskCancel
+		}
+
+	case cmdMltReq:
+		switch d[1] & 0x03 {
+		case 1:
+			s.players = 2
+		case 3:
+			s.players = 4
+		default:
+			s.players = 1
+		}
+
+		s.player = 0
+
+	case cmdPalTrn, cmdChrTrn, cmdPctTrn:
+		s.transfer = cmd
+		s.pending = true
+
+	case cmdMaskEn:
+		s.mask = d[1] & 0x03
+
+	default:
+		logger.Printf("unimplemented SGB command 0x%02x", cmd)
+
+	}
+}
+
+// Set colours 1-3 of palettes a and b, and colour 0 of all palettes (PAL01, PAL23, PAL03 and PAL12).
+func (s *SGB) setPalettes(a, b int, d []uint8) {
+	colour := func(i int) color.RGBA {
+		return colour15(uint16(d[i]) | uint16(d[i+1])<<8)
+	}
+
+	for i := 1; i < 4; i++ {
+		s.palettes[a][i] = colour(1 + i*2)
+		s.palettes[b][i] = colour(7 + i*2)
+	}
+
+	s.shareColour0(colour(1))
+}
+
+// Set colour 0 of all palettes, which is also the border's background colour.
+func (s *SGB) shareColour0(c color.RGBA) {
+	for i := range s.palettes {
+		s.palettes[i][0] = c
+	}
+
+	s.dirty = true
+}
+
+// Set the palette of blocks in the attribute map (ATTR_BLK).
+func (s *SGB) attrBlock(d []uint8) {
+	for n := 0; n < int(d[1]) && n < 18; n++ {
+		b := d[2+n*6:]
+		ctrl, pals := b[0], b[1]
+		x1, y1, x2, y2 := int(b[2]&0x1f), int(b[3]&0x1f), int(b[4]&0x1f), int(b[5]&0x1f)
+
+		inside := ctrl&0x01 != 0
+		edge := ctrl&0x02 != 0
+		outside := ctrl&0x04 != 0
+
+		in, on, out := pals&0x03, (pals>>2)&0x03, (pals>>4)&0x03
+
+		// If only the inside or outside is changed, the edge of the block is changed along with it.
+		switch {
+
+		case inside && !edge && !outside:
+			edge, on = true, in
+
+		case outside && !edge && !inside:
+			edge, on = true, out
+
+		}
+
+		for y := 0; y < attrHeight; y++ {
+			for x := 0; x < attrWidth; x++ {
+				attr := &s.attrs[y*attrWidth+x]
+
+				switch {
+
+				case x > x1 && x < x2 && y > y1 && y < y2:
+					if inside {
+						*attr = in
+					}
+
+				case x < x1 || x > x2 || y < y1 || y > y2:
+					if outside {
+						*attr = out
+					}
+
+				default:
+					if edge {
+						*attr = on
+					}
+
+				}
+			}
+		}
+	}
+}
+
+// Set the palette of rows or columns in the attribute map (ATTR_LIN).
+func (s *SGB) attrLine(d []uint8) {
+	for n := 0; n < int(d[1]) && 2+n < len(d); n++ {
+		b := d[2+n]
+		line := int(b & 0x1f)
+		pal := (b >> 5) & 0x03
+
+		// Bit 7 selects a row instead of a column.
+		if (b & 0x80) != 0 {
+			if line < attrHeight {
+				for x := 0; x < attrWidth; x++ {
+					s.attrs[line*attrWidth+x] = pal
+				}
+			}
+		} else if line < attrWidth {
+			for y := 0; y < attrHeight; y++ {
+				s.attrs[y*attrWidth+line] = pal
+			}
+		}
+	}
+}
+
+// Divide the attribute map into two halves and the line between them, setting the palette of each (ATTR_DIV).
+func (s *SGB) attrDivide(d []uint8) {
+	b := d[1]
+	after, before, on := b&0x03, (b>>2)&0x03, (b>>4)&0x03
+	div := int(d[2] & 0x1f)
+
+	for y := 0; y < attrHeight; y++ {
+		for x := 0; x < attrWidth; x++ {
+			// Bit 6 divides the map horizontally instead of vertically.
+			pos := x
+			if (b & 0x40) != 0 {
+				pos = y
+			}
+
+			attr := &s.attrs[y*attrWidth+x]
+
+			switch {
+
+			case pos < div:
+				*attr = before
+
+			case pos > div:
+				*attr = after
+
+			default:
+				*attr = on
+
+			}
+		}
+	}
+}
+
+// Set the palette of each area in the attribute map, starting from a position (ATTR_CHR).
+func (s *SGB) attrChar(d []uint8) {
+	x, y := int(d[1]&0x1f), int(d[2]&0x1f)
+	count := int(d[3]) | int(d[4]&0x01)<<8
+	vertical := (d[5] & 0x01) != 0
+
+	// Each byte holds four palettes, starting from the upper bits.
+	for n := 0; n < count && 6+n/4 < len(d); n++ {
+		if x >= attrWidth || y >= attrHeight {
+			break
+		}
+
+		s.attrs[y*attrWidth+x] = (d[6+n/4] >> (6 - (n%4)*2)) & 0x03
+
+		if vertical {
+			if y++; y == attrHeight {
+				y = 0
+				x++
+			}
+		} else {
+			if x++; x == attrWidth {
+				x = 0
+				y++
+			}
+		}
+	}
+}
+
+// Return the colour of a pixel on the game screen with the shade, or nil if the screen is frozen.
+func (s *SGB) colour(x, y int, shade uint8) *color.RGBA {
+	s.shades[y*renderWidth+x] = shade
+
+	switch s.mask {
+
+	case maskFreeze:
+		return nil
+
+	case maskBlack:
+		return &Black
+
+	case maskColour0:
+		return &s.palettes[0][0]
+
+	}
+
+	return &s.palettes[s.attrs[(y/8)*attrWidth+x/8]][shade]
+}
+
+// Run a pending VRAM transfer once a frame has been drawn.
+func (s *SGB) vblank() {
+	if !s.pending {
+		return
+	}
+
+	s.pending = false
+	data := s.screenData()
+
+	switch s.transfer {
+
+	case cmdPalTrn:
+		for i := range s.system {
+			for c := 0; c < 4; c++ {
+				offset := i*8 + c*2
+				s.system[i][c] = colour15(uint16(data[offset]) | uint16(data[offset+1])<<8)
+			}
+		}
+
+	case cmdChrTrn:
+		// Bit 0 of the command selects whether the upper or lower half of the tiles are sent.
+		start := 0
+		if (s.data[1] & 0x01) != 0 {
+			start = 128
+		}
+
+		for t := 0; t < 128; t++ {
+			s.tiles[start+t] = snesTile(data[t*32 : t*32+32])
+		}
+
+		s.dirty = true
+
+	case cmdPctTrn:
+		for i := range s.tileMap {
+			s.tileMap[i] = uint16(data[i*2]) | uint16(data[i*2+1])<<8
+		}
+
+		for p := range s.borderPals {
+			for c := range s.borderPals[p] {
+				offset := 0x800 + p*32 + c*2
+				s.borderPals[p][c] = colour15(uint16(data[offset]) | uint16(data[offset+1])<<8)
+			}
+		}
+
+		s.dirty = true
+
+	}
+}
+
+// Read the data sent by a VRAM transfer from the shades on screen.
+// The screen is read as 2-bit tiles, 20 tiles to a row.
+func (s *SGB) screenData() []uint8 {
+	data := make([]uint8, sgbTransferSize)
+
+	for t := 0; t < sgbTransferSize/16; t++ {
+		tx, ty := (t%attrWidth)*8, (t/attrWidth)*8
+
+		for row := 0; row < 8; row++ {
+			var lo, hi uint8
+
+			for x := 0; x < 8; x++ {
+				shade := s.shades[(ty+row)*renderWidth+tx+x]
+
+				lo |= (shade & 0x01) << (7 - x)
+				hi |= (shade >> 1) << (7 - x)
+			}
+
+			data[t*16+row*2] = lo
+			data[t*16+row*2+1] = hi
+		}
+	}
+
+	return data
+}
+
+// Decode a SNES tile with 4 bits per pixel, where the first 16 bytes hold bits 0-1 and the last 16 bytes hold bits 2-3.
+func snesTile(b []uint8) Tile {
+	var tile Tile
+
+	for y := 0; y < 8; y++ {
+		planes := [4]uint8{b[y*2], b[y*2+1], b[16+y*2], b[16+y*2+1]}
+
+		for x := 0; x < 8; x++ {
+			var c uint8
+			for i, plane := range planes {
+				c |= ((plane >> (7 - x)) & 0x01) << i
+			}
+
+			tile[y][x] = c
+		}
+	}
+
+	return tile
+}
+
+// Redraw the border from its tile map.
+func (s *SGB) drawBorder() {
+	for ty := 0; ty < sgbHeight/8; ty++ {
+		for tx := 0; tx < sgbWidth/8; tx++ {
+			entry := s.tileMap[ty*32+tx]
+			tile := &s.tiles[entry&0xff]
+
+			// Bits 10-12 select palettes 4-7, and bits 14 and 15 flip the tile.
+			pal := &s.borderPals[(entry>>10)&0x03]
+			xflip := (entry & 0x4000) != 0
+			yflip := (entry & 0x8000) != 0
+
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					px, py := x, y
+					if xflip {
+						px = 7 - x
+					}
+					if yflip {
+						py = 7 - y
+					}
+
+					// Colour 0 is transparent, showing the background colour.
+					c := &s.palettes[0][0]
+					if i := tile[py][px]; i != 0 {
+						c = &pal[i]
+					}
+
+					s.border.Write(tx*8+x, ty*8+y, c)
+				}
+			}
+		}
+	}
+}
+
+// Draw the border onto a screen, redrawing it if it has changed.
+func (s *SGB) Draw(screen *ebiten.Image) {
+	if s.image == nil {
+		s.image = ebiten.NewImage(sgbWidth, sgbHeight)
+	}
+
+	if s.dirty {
+		s.drawBorder()
+		s.border.CopyInto(s.image)
+		s.dirty = false
+	}
+
+	screen.DrawImage(s.image, &ebiten.DrawImageOptions{})
+}
@@ -21,6 +21,12 @@ stopped: %t
 	cps = 4194304
 	fps = 60
 	cpf = cps / fps
+
+	// The number of frames between autosaves of battery-backed RAM.
+	autosaveFrames = fps * 5
+
+	// The number of machine cycles the CPU is paused for during a speed switch.
+	speedSwitchCycles = 2050
 )
 
 // State represents the current state of the emulation at some point in time.
@@ -36,6 +42,12 @@ type State struct {
 	image *ebiten.Image
 
 	stopped bool
+
+	// HALT stops the CPU until an interrupt is pending.
+	// If an interrupt is already pending when IME is clear, the CPU doesn't halt but reads the next byte twice instead.
+	halted, haltBug bool
+
+	frames int
 }
 
 func NewState() *State {
@@ -53,6 +65,7 @@ func NewState() *State {
 	}
 
 	s.MMU = NewMMU()
+	s.postBoot()
 
 	s.fl = NewFlags(s.AF)
 
@@ -67,11 +80,48 @@ func (s *State) Update(screen *ebiten.Image) {
 		s.step()
 	}
 
-	logger.Println("copying framebuffer to screen")
-	screen.DrawImage(s.image, &ebiten.DrawImageOptions{})
+	// Present the latest complete frame, or keep the previous one if no frame was completed.
+	s.render.fb.Present(s.image)
+
+	// In SGB mode, the screen is drawn in the middle of the border.
+	op := &ebiten.DrawImageOptions{}
+	if s.sgb != nil {
+		s.sgb.Draw(screen)
+		op.GeoM.Translate(borderX, borderY)
+	}
+
+	screen.DrawImage(s.image, op)
+
+	s.frames++
+	if s.frames%autosaveFrames == 0 {
+		s.autosave()
+	}
 }
 
 func (s *State) step() {
+	// Interrupts are serviced before the next instruction, which also wakes the CPU from HALT.
+	if s.intr.master && s.intr.pending() != 0 {
+		s.halted = false
+		s.dispatch()
+		s.MMU.step(s.clock.delta())
+
+		return
+	}
+
+	if s.halted {
+		// The CPU wakes up once an interrupt is pending, even if IME is clear.
+		if s.intr.pending() == 0 {
+			s.clock.step(1)
+			s.MMU.step(s.clock.delta())
+
+			return
+		}
+
+		s.halted = false
+	}
+
+	s.intr.update()
+
 	var ins Instruction
 
 	opcode := s.fetch()
@@ -96,45 +146,68 @@ func (s *State) step() {
 
 	ins.fn(s, value)
 	s.clock.step(ins.cycles)
-	s.render.step(s.clock)
 
-	// handle interrupts
-	ir := s.render.intr.todo()
-	if ir != 0 {
-		s.Push(s.PC)
+	// The CPU is halted while VRAM DMA copies data.
+	s.clock.step(s.hdmaStall())
+	s.MMU.step(s.clock.delta())
+}
 
-		var cycles int
-		switch {
+// Service the pending interrupt with the highest priority, pushing PC and jumping to its handler.
+func (s *State) dispatch() {
+	s.intr.master = false
 
-		case (ir & VBlank) != 0:
-			s.render.fb.CopyInto(s.image)
-			s.PC = 0x40
-			cycles += 3
+	s.SP--
+	s.Write(s.SP, uint8(s.PC>>8))
 
-		case (ir & LCDStat) != 0:
-			s.PC = 0x48
-			cycles += 3
+	// The interrupt is only chosen after the upper byte of PC is pushed, which may have overwritten IE.
+	// If there is no interrupt left to service, PC is set to 0 instead.
+	flag := s.intr.highest()
 
-		case (ir & Timer) != 0:
-			s.PC = 0x50
-			cycles += 3
+	s.SP--
+	s.Write(s.SP, uint8(s.PC))
 
-		case (ir & Serial) != 0:
-			s.PC = 0x58
-			cycles += 3
+	if flag == 0 {
+		s.PC = 0
+	} else {
+		s.intr.requested &^= flag
+		s.PC = vector(flag)
+	}
 
-		case (ir & Joypad) != 0:
-			s.PC = 0x60
-			cycles += 3
+	s.clock.step(dispatchCycles)
+}
 
-		}
+// Run the HALT instruction.
+func (s *State) halt() {
+	if !s.intr.master && s.intr.pending() != 0 {
+		s.haltBug = true
+		return
+	}
+
+	s.halted = true
+}
 
-		s.clock.step(cycles)
+// Run the STOP instruction, which switches speed if a speed switch was prepared in CGB mode.
+func (s *State) stop() {
+	if !s.switchSpeed() {
+		s.stopped = true
+		return
 	}
+
+	s.clock.double = s.double
+
+	// The CPU is paused while the speed switch is done.
+	s.clock.step(speedSwitchCycles)
 }
 
 func (s *State) fetch() uint8 {
 	b := s.Read(s.PC)
+
+	// Due to the HALT bug, PC isn't incremented after reading the next byte.
+	if s.haltBug {
+		s.haltBug = false
+		return b
+	}
+
 	s.PC++
 	return b
 }
@@ -191,17 +264,106 @@ func (s *State) LoadBootFrom(rom io.Reader) error {
 		return err
 	}
 
+	s.boot()
+
+	return nil
+}
+
+// Set the hardware registers left by the bootrom, which also leaves the LCD and APU on.
+// https://gbdev.io/pandocs/Power_Up_Sequence.html#hardware-registers
+func (s *State) postBoot() {
+	s.Write(0xff40, 0x91)
+	s.Write(0xff47, 0xfc)
+	s.Write(0xff26, 0xf1)
+	s.Write(0xff25, 0xf3)
+	s.Write(0xff24, 0x77)
+}
+
+// Start running the bootrom, undoing the hardware registers set by postBoot so the bootrom sets them itself.
+// The LCD and APU start off, and turning the APU off also clears NR50 and NR51.
+func (s *State) boot() {
+	if s.cgb {
+		s.skipBoot()
+		return
+	}
+
 	s.PC = 0
 
+	s.Write(0xff40, 0x00)
+	s.Write(0xff47, 0x00)
+	s.Write(0xff26, 0x00)
+}
+
+// Only DMG-sized bootroms can be loaded, which can't start games in CGB mode, so the bootrom is skipped for them.
+func (s *State) skipBoot() {
+	logger.Printf("skipping the DMG bootrom, which can't start games in CGB mode")
+
+	s.hasBoot = false
+	s.PC = 0x100
+
+	s.postBoot()
+	s.resetRegisters()
+}
+
+func (s *State) LoadFrom(rom io.Reader) error {
+	if err := s.MMU.LoadFrom(rom); err != nil {
+		return err
+	}
+
+	if s.hasBoot && s.cgb {
+		s.skipBoot()
+	}
+
+	s.resetRegisters()
+
+	return nil
+}
+
+func (s *State) Load(rom string) error {
+	if err := s.MMU.Load(rom); err != nil {
+		return err
+	}
+
+	if s.hasBoot && s.cgb {
+		s.skipBoot()
+	}
+
+	s.resetRegisters()
+
 	return nil
 }
 
+// Set the registers to the values left by the CGB or SGB bootrom, if running in either mode without a bootrom.
+// Games check the value of A to detect which model they are running on.
+// https://gbdev.io/pandocs/Power_Up_Sequence.html#cpu-registers
+func (s *State) resetRegisters() {
+	if s.hasBoot {
+		return
+	}
+
+	switch {
+
+	case s.cgb:
+		s.AF.Set(0x1180)
+		s.BC.Set(0x0000)
+		s.DE.Set(0xff56)
+		s.HL.Set(0x000d)
+
+	case s.sgb != nil:
+		s.AF.Set(0x0100)
+		s.BC.Set(0x0014)
+		s.DE.Set(0x0000)
+		s.HL.Set(0xc060)
+
+	}
+}
+
 func (s *State) LoadBoot(rom string) error {
 	if err := s.MMU.LoadBoot(rom); err != nil {
 		return err
 	}
 
-	s.PC = 0
+	s.boot()
 
 	return nil
 }
@@ -0,0 +1,125 @@
+package tamago
+
+// The bit of the internal divider that clocks TIMA, indexed by the clock select in TAC (bits 0-1).
+// TIMA increments when the bit falls from 1 to 0, so this is equivalent to 4096, 262144, 65536 and 16384 Hz respectively.
+var timerBits = [...]uint{9, 3, 5, 7}
+
+// timer is the divider (DIV) and timer (TIMA, TMA, TAC) registers.
+// https://gbdev.io/pandocs/Timer_and_Divider_Registers.html
+type timer struct {
+	// The internal divider is incremented every cycle, and DIV is its upper 8 bits.
+	div uint16
+
+	tima, tma, tac uint8
+
+	// When TIMA overflows, it stays at 0 for one machine cycle before being reloaded with TMA.
+	overflow bool
+
+	// True during the machine cycle TIMA is reloaded in, where writes to TIMA are ignored and writes to TMA go through to TIMA.
+	reloaded bool
+
+	intr *Interrupt
+}
+
+func newTimer(intr *Interrupt) *timer {
+	return &timer{intr: intr}
+}
+
+// Given the number of cycles run, step forward the timer one machine cycle at a time.
+func (t *timer) step(cycles int) {
+	for i := 0; i < cycles; i += 4 {
+		t.tick()
+	}
+}
+
+// Run the timer for a single machine cycle.
+func (t *timer) tick() {
+	t.reloaded = false
+
+	if t.overflow {
+		t.overflow = false
+		t.reloaded = true
+
+		t.tima = t.tma
+		t.intr.requested |= Timer
+	}
+
+	t.setDiv(t.div + 4)
+}
+
+// Check if the timer is enabled and the selected divider bit is set.
+func (t *timer) signal() bool {
+	return (t.tac&0x04) != 0 && ((t.div>>timerBits[t.tac&0x03])&1) != 0
+}
+
+// Set the internal divider, incrementing TIMA on a falling edge.
+func (t *timer) setDiv(v uint16) {
+	before := t.signal()
+	t.div = v
+
+	if before && !t.signal() {
+		t.increment()
+	}
+}
+
+func (t *timer) increment() {
+	t.tima++
+
+	if t.tima == 0 {
+		t.overflow = true
+	}
+}
+
+func (t *timer) read(addr uint16) uint8 {
+	switch addr {
+
+	case 0xff04:
+		return uint8(t.div >> 8)
+
+	case 0xff05:
+		return t.tima
+
+	case 0xff06:
+		return t.tma
+
+	case 0xff07:
+		// The upper 5 bits are unused.
+		return t.tac | 0xf8
+
+	}
+
+	return 0xff
+}
+
+func (t *timer) write(addr uint16, val uint8) {
+	switch addr {
+
+	// Writing any value resets the whole divider, which can also cause a falling edge.
+	case 0xff04:
+		t.setDiv(0)
+
+	// Writing to TIMA while it overflowed cancels the reload.
+	case 0xff05:
+		if !t.reloaded {
+			t.tima = val
+			t.overflow = false
+		}
+
+	case 0xff06:
+		t.tma = val
+
+		if t.reloaded {
+			t.tima = val
+		}
+
+	// Disabling the timer or changing the clock select can also cause a falling edge.
+	case 0xff07:
+		before := t.signal()
+		t.tac = val & 0x07
+
+		if before && !t.signal() {
+			t.increment()
+		}
+
+	}
+}
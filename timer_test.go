@@ -0,0 +1,156 @@
+package tamago
+
+import "testing"
+
+func TestTimerFrequency(t *testing.T) {
+	tests := []struct {
+		tac uint8
+
+		// The number of machine cycles between each increment of TIMA.
+		cycles int
+	}{
+		{0x04, 256},
+		{0x05, 4},
+		{0x06, 16},
+		{0x07, 64},
+	}
+
+	for _, tt := range tests {
+		tm := newTimer(NewInterrupt())
+		tm.write(0xff07, tt.tac)
+
+		for i := 1; i <= tt.cycles*3; i++ {
+			tm.tick()
+
+			if expected := uint8(i / tt.cycles); tm.tima != expected {
+				t.Fatalf("TAC 0x%02x: TIMA is %d after %d cycles, expected %d", tt.tac, tm.tima, i, expected)
+			}
+		}
+	}
+}
+
+func TestTimerDisabled(t *testing.T) {
+	tm := newTimer(NewInterrupt())
+	tm.write(0xff07, 0x01)
+
+	tm.step(4 * 1024)
+
+	if tm.tima != 0 {
+		t.Errorf("TIMA incremented to %d while the timer is disabled", tm.tima)
+	}
+
+	if div := tm.read(0xff04); div != 0x10 {
+		t.Errorf("DIV is 0x%02x, expected 0x10", div)
+	}
+}
+
+// Create a timer where TIMA has just overflowed, and will be reloaded on the next cycle.
+func overflowedTimer(t *testing.T) *timer {
+	tm := newTimer(NewInterrupt())
+	tm.write(0xff06, 0xab)
+	tm.write(0xff05, 0xff)
+	tm.write(0xff07, 0x05)
+
+	for i := 0; i < 4; i++ {
+		tm.tick()
+	}
+
+	if tm.tima != 0 || !tm.overflow {
+		t.Fatalf("TIMA is 0x%02x after overflowing, expected 0", tm.tima)
+	}
+
+	return tm
+}
+
+func TestTimerOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// Run between the cycle TIMA overflows and the next.
+		before func(tm *timer)
+		// Run after the next cycle.
+		after func(tm *timer)
+
+		tima      uint8
+		interrupt bool
+	}{
+		{
+			name:      "reloaded after one cycle",
+			tima:      0xab,
+			interrupt: true,
+		},
+		{
+			name:   "writing TIMA cancels the reload",
+			before: func(tm *timer) { tm.write(0xff05, 0x10) },
+			tima:   0x10,
+		},
+		{
+			name:      "writing TIMA in the reload cycle is ignored",
+			after:     func(tm *timer) { tm.write(0xff05, 0x20) },
+			tima:      0xab,
+			interrupt: true,
+		},
+		{
+			name:      "writing TMA in the reload cycle also writes TIMA",
+			after:     func(tm *timer) { tm.write(0xff06, 0x33) },
+			tima:      0x33,
+			interrupt: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := overflowedTimer(t)
+
+			if (tm.intr.requested & Timer) != 0 {
+				t.Fatal("Timer interrupt requested before TIMA was reloaded")
+			}
+
+			if tt.before != nil {
+				tt.before(tm)
+			}
+
+			tm.tick()
+
+			if tt.after != nil {
+				tt.after(tm)
+			}
+
+			if tm.tima != tt.tima {
+				t.Errorf("TIMA is 0x%02x, expected 0x%02x", tm.tima, tt.tima)
+			}
+
+			if interrupt := (tm.intr.requested & Timer) != 0; interrupt != tt.interrupt {
+				t.Errorf("Timer interrupt requested is %t, expected %t", interrupt, tt.interrupt)
+			}
+		})
+	}
+}
+
+func TestTimerFallingEdge(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(tm *timer)
+	}{
+		{"resetting DIV", func(tm *timer) { tm.write(0xff04, 0) }},
+		{"disabling the timer", func(tm *timer) { tm.write(0xff07, 0x01) }},
+		{"changing the clock select", func(tm *timer) { tm.write(0xff07, 0x04) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := newTimer(NewInterrupt())
+			tm.write(0xff07, 0x05)
+
+			// Set bit 3 of the divider, which is selected by TAC 0x05.
+			tm.tick()
+			tm.tick()
+
+			tt.write(tm)
+
+			if tm.tima != 1 {
+				t.Errorf("TIMA is %d, expected the falling edge to increment it to 1", tm.tima)
+			}
+		})
+	}
+}